@@ -10,7 +10,26 @@ const (
 	TokenEOF       TokenType = "EOF"
 )
 
-// Position captures where a piece of text lives on the page.
+// Script marks text set as a superscript or subscript.
+type Script string
+
+const (
+	ScriptSuper Script = "super"
+	ScriptSub   Script = "sub"
+)
+
+// Direction is the direction text is read in.
+type Direction string
+
+const (
+	DirectionLTR Direction = "ltr"
+	DirectionRTL Direction = "rtl"
+)
+
+// Position captures where a piece of text lives on the page. Coordinates
+// are in page space: points from the bottom-left corner of the MediaBox as
+// the page is displayed, after its /Rotate. For an unrotated page whose
+// MediaBox starts at 0, 0 that is PDF user space.
 type Position struct {
 	Page     int     `json:"page"`
 	X        float64 `json:"x"`
@@ -18,6 +37,60 @@ type Position struct {
 	Width    float64 `json:"width"`
 	Font     string  `json:"font,omitempty"`
 	FontSize float64 `json:"fontSize,omitempty"`
+	// X0, Y0, X1, Y1 bound the text from the font's descent to its ascent.
+	X0 float64 `json:"x0"`
+	Y0 float64 `json:"y0"`
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+	// Baseline is the Y of the line the text sits on; Y differs from it when
+	// the text is raised or lowered with Ts.
+	Baseline float64 `json:"baseline"`
+	// Rotation is the text direction in degrees counter-clockwise.
+	Rotation float64 `json:"rotation,omitempty"`
+	// Vertical is set for text in a vertical writing mode font, which runs
+	// top to bottom.
+	Vertical bool `json:"vertical,omitempty"`
+	// CharSpacing is the extra space after each character (Tc), in points.
+	CharSpacing float64 `json:"charSpacing,omitempty"`
+	// Script is set for text that is smaller than the rest of its line and
+	// raised above or lowered below its baseline.
+	Script Script `json:"script,omitempty"`
+	// Monospace is set for text in a fixed-pitch font.
+	Monospace bool `json:"monospace,omitempty"`
+	// Bold is set for text drawn several times over to look bold.
+	Bold bool `json:"bold,omitempty"`
+	// Watermark is set for watermark and background text, which is only
+	// kept with Options.KeepWatermarks.
+	Watermark bool `json:"watermark,omitempty"`
+	// Element is the ID of the innermost structure element the text
+	// belongs to in a Tagged PDF; see DocumentNode.Structure.
+	Element int `json:"element,omitempty"`
+	// Tag is the tag of the innermost marked-content sequence (BMC/BDC)
+	// around the text, such as P, Span or Artifact.
+	Tag string `json:"tag,omitempty"`
+	// Artifact is set for text in /Artifact marked content, which is only
+	// kept with Options.KeepArtifacts: the artifact's subtype (Header,
+	// Footer, Watermark), its type (Pagination, Layout, Page, Background),
+	// or "Artifact". Text outside marked content that repeats at the foot of
+	// every page is taken for a running footer and gets "Footer".
+	Artifact string `json:"artifact,omitempty"`
+	// RenderMode is the PDF text render mode (Tr); 3 means invisible.
+	RenderMode int `json:"renderMode,omitempty"`
+	// Fill is the non-stroking colour as #rrggbb.
+	Fill string `json:"fill,omitempty"`
+	// Clipped is set when the text lies outside the active clipping path.
+	Clipped bool `json:"clipped,omitempty"`
+	// OffPage is set for text outside the page's CropBox, such as printer's
+	// marks and job tickets, which is only kept with Options.KeepOffPage.
+	OffPage bool `json:"offPage,omitempty"`
+	// OCR marks text recognised by an OCREngine.
+	OCR bool `json:"ocr,omitempty"`
+}
+
+// Invisible reports whether the text is never painted on the page, either
+// because of its render mode or because it is clipped away.
+func (p Position) Invisible() bool {
+	return p.RenderMode == 3 || p.RenderMode == 7 || p.Clipped
 }
 
 // Token is the output of the lexer.
@@ -29,18 +102,49 @@ type Token struct {
 
 // DocumentNode is the AST root.
 type DocumentNode struct {
-	Pages []PageNode `json:"pages"`
+	// Direction is the direction most of the document's text runs in, for
+	// renderers that mark right-to-left documents, e.g. with dir="rtl".
+	Direction Direction  `json:"direction,omitempty"`
+	Pages     []PageNode `json:"pages"`
+	// Structure is the logical structure tree of a Tagged PDF, in document
+	// order. It is empty for untagged documents.
+	Structure []StructElement `json:"structure,omitempty"`
 }
 
 // PageNode groups blocks on a page.
 type PageNode struct {
-	Number int         `json:"number"`
+	Number int `json:"number"`
+	PageGeometry
 	Blocks []BlockNode `json:"blocks"`
 }
 
+// Box is a rectangle in page space (see Position): its lower-left and
+// upper-right corners, x0 y0 x1 y1.
+type Box [4]float64
+
+// PageGeometry describes a page's size, orientation and printed label.
+type PageGeometry struct {
+	// Label is the page number printed on the page as the document's
+	// /PageLabels give it, such as "iv" or "A-3". It is empty when the
+	// document has no labels.
+	Label string `json:"label,omitempty"`
+	// Width and Height are the size of the visible page, the CropBox, as
+	// displayed: swapped for pages rotated by 90 or 270 degrees.
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// MediaBox is the whole sheet and CropBox the part of it shown, both
+	// in page space, so the MediaBox starts at 0, 0.
+	MediaBox Box `json:"mediaBox"`
+	CropBox  Box `json:"cropBox"`
+	// Rotation is the page's /Rotate: 0, 90, 180 or 270 degrees clockwise.
+	Rotation int `json:"rotation,omitempty"`
+}
+
 // BlockNode is a sequence of lines (e.g., a paragraph).
 type BlockNode struct {
-	Lines []LineNode `json:"lines"`
+	// Direction is the direction most of the block's text runs in.
+	Direction Direction  `json:"direction,omitempty"`
+	Lines     []LineNode `json:"lines"`
 }
 
 // LineNode is a line of spans.
@@ -0,0 +1,213 @@
+package yapp
+
+import "unicode"
+
+// bidiClass is the bidirectional character type of a rune, reduced to what
+// logicalOrder needs.
+type bidiClass int
+
+const (
+	bidiNeutral bidiClass = iota // spaces, punctuation, symbols
+	bidiL                        // left-to-right letters
+	bidiR                        // right-to-left letters
+	bidiEN                       // European digits
+	bidiAN                       // Arabic digits
+)
+
+// rtlScripts are the scripts written right to left.
+var rtlScripts = []*unicode.RangeTable{
+	unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko,
+}
+
+func classify(r rune) bidiClass {
+	switch {
+	case unicode.IsDigit(r):
+		if unicode.Is(unicode.Arabic, r) {
+			return bidiAN
+		}
+		return bidiEN
+	case unicode.In(r, rtlScripts...):
+		return bidiR
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return bidiL
+	}
+	return bidiNeutral
+}
+
+// directionOf counts the strong left-to-right and right-to-left letters of s.
+func directionOf(s string) (ltr, rtl int) {
+	for _, r := range s {
+		switch classify(r) {
+		case bidiL:
+			ltr++
+		case bidiR:
+			rtl++
+		}
+	}
+	return ltr, rtl
+}
+
+// majorityDirection is the direction most of the letters counted run in.
+func majorityDirection(ltr, rtl int) Direction {
+	if rtl > ltr {
+		return DirectionRTL
+	}
+	return DirectionLTR
+}
+
+// bidiMirror pairs the characters whose glyph is mirrored in right-to-left
+// text.
+var bidiMirror = map[rune]rune{
+	'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{',
+	'<': '>', '>': '<', '«': '»', '»': '«', '‹': '›', '›': '‹',
+}
+
+// logicalOrder puts the words of a line, which arrive in visual order from
+// left to right, into the order they are read in. It runs a simplified
+// Unicode Bidirectional Algorithm over the line: the paragraph direction is
+// that of most of its letters, and levels are resolved from the character
+// types (rules W2, W7, N1, N2 and I1-I2) before runs are reversed (L2) and
+// mirrored characters swapped (L4). Reordering is its own inverse, so
+// applying it to visual order yields logical order. Numbers keep their
+// digits left to right inside right-to-left text. Lines without
+// right-to-left letters are returned as they are.
+func logicalOrder(words []Token) []Token {
+	var runes []rune
+	var owner []int
+	ltr, rtl := 0, 0
+	for i, w := range words {
+		if i > 0 {
+			runes = append(runes, ' ')
+			owner = append(owner, -1)
+		}
+		for _, r := range w.Lexeme {
+			runes = append(runes, r)
+			owner = append(owner, i)
+		}
+		l, r := directionOf(w.Lexeme)
+		ltr, rtl = ltr+l, rtl+r
+	}
+	if rtl == 0 {
+		return words
+	}
+	base := 0
+	if majorityDirection(ltr, rtl) == DirectionRTL {
+		base = 1
+	}
+
+	classes := make([]bidiClass, len(runes))
+	strong := bidiL
+	if base == 1 {
+		strong = bidiR
+	}
+	for i, r := range runes {
+		c := classify(r)
+		switch c {
+		case bidiL, bidiR:
+			strong = c
+		case bidiEN:
+			// W2 and W7: digits take the type of the letters before them
+			// when those run left to right.
+			if strong == bidiL {
+				c = bidiL
+			}
+		}
+		classes[i] = c
+	}
+	// N1 and N2: neutrals between text of one direction take that
+	// direction, numbers counting as right to left; others take the
+	// paragraph's.
+	for i := 0; i < len(classes); {
+		if classes[i] != bidiNeutral {
+			i++
+			continue
+		}
+		j := i
+		for j < len(classes) && classes[j] == bidiNeutral {
+			j++
+		}
+		before, after := base == 1, base == 1
+		if i > 0 {
+			before = classes[i-1] != bidiL
+		}
+		if j < len(classes) {
+			after = classes[j] != bidiL
+		}
+		c := bidiL
+		if before == after && before || before != after && base == 1 {
+			c = bidiR
+		}
+		for k := i; k < j; k++ {
+			classes[k] = c
+		}
+		i = j
+	}
+
+	// I1 and I2.
+	levels := make([]int, len(runes))
+	maxLevel := 0
+	for i, c := range classes {
+		level := base
+		switch {
+		case base == 0 && c == bidiR:
+			level = 1
+		case base == 0 && (c == bidiEN || c == bidiAN):
+			level = 2
+		case base == 1 && c != bidiR:
+			level = 2
+		}
+		levels[i] = level
+		maxLevel = max(maxLevel, level)
+	}
+
+	order := make([]int, len(runes))
+	for i := range order {
+		order[i] = i
+	}
+	for level := maxLevel; level >= 1; level-- {
+		for i := 0; i < len(order); {
+			if levels[order[i]] < level {
+				i++
+				continue
+			}
+			j := i
+			for j < len(order) && levels[order[j]] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+
+	// Rebuild the words in the order their first characters now come in.
+	out := make([]Token, 0, len(words))
+	slot := make([]int, len(words))
+	for i := range slot {
+		slot[i] = -1
+	}
+	texts := make([][]rune, 0, len(words))
+	for _, k := range order {
+		w := owner[k]
+		if w < 0 {
+			continue
+		}
+		r := runes[k]
+		if levels[k]%2 == 1 {
+			if m, ok := bidiMirror[r]; ok {
+				r = m
+			}
+		}
+		if slot[w] < 0 {
+			slot[w] = len(out)
+			out = append(out, words[w])
+			texts = append(texts, nil)
+		}
+		texts[slot[w]] = append(texts[slot[w]], r)
+	}
+	for i := range out {
+		out[i].Lexeme = string(texts[i])
+	}
+	return out
+}
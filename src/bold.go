@@ -0,0 +1,94 @@
+package yapp
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// overdrawShift is how far, in font sizes, a copy of a glyph can be
+	// from the original and still be an overdraw of it.
+	overdrawShift = 0.1
+	// overdrawAdvance caps overdrawShift at a share of the glyph's advance,
+	// so repeated narrow letters such as "ll" stay apart.
+	overdrawAdvance = 0.4
+	// overdrawCell is the size, in points, of the grid cells glyphs are
+	// looked up in when searching for copies.
+	overdrawCell = 4
+)
+
+// overdrawKey is a glyph's text and the grid cell it starts in.
+type overdrawKey struct {
+	s    string
+	x, y int
+}
+
+func newOverdrawKey(g glyph) overdrawKey {
+	return overdrawKey{g.S, int(math.Floor(g.X / overdrawCell)), int(math.Floor(g.Y / overdrawCell))}
+}
+
+// collapseOverdraw removes the copies of glyphs that some generators draw
+// two or three times with a small offset to fake bold or a shadow. The copy
+// drawn last, which is the one on top, stays and is marked bold. Glyphs are
+// looked up by position, so a page of the same letter over and over takes
+// no longer than any other.
+func collapseOverdraw(glyphs []glyph) []glyph {
+	kept := glyphs[:0]
+	seen := make(map[overdrawKey][]int) // indexes into kept by text and cell
+	for _, g := range glyphs {
+		if strings.TrimSpace(g.S) == "" {
+			kept = append(kept, g)
+			continue
+		}
+		// Copies differ in size by 5% at most, and in position by a tenth
+		// of that.
+		reach := int(math.Ceil(g.FontSize * 1.05 * overdrawShift / overdrawCell))
+		key := newOverdrawKey(g)
+		dup := -1
+		for dx := -reach; dx <= reach && dup < 0; dx++ {
+			for dy := -reach; dy <= reach && dup < 0; dy++ {
+				for _, i := range seen[overdrawKey{g.S, key.x + dx, key.y + dy}] {
+					if overdraws(kept[i], g) {
+						dup = i
+						break
+					}
+				}
+			}
+		}
+		if dup >= 0 {
+			// The copy on top replaces the original, and is filed where it
+			// lies for the next copy to find.
+			old := newOverdrawKey(kept[dup])
+			seen[old] = removeIndex(seen[old], dup)
+			g.bold = true
+			kept[dup] = g
+			seen[key] = append(seen[key], dup)
+			continue
+		}
+		seen[key] = append(seen[key], len(kept))
+		kept = append(kept, g)
+	}
+	return kept
+}
+
+// removeIndex returns list without i.
+func removeIndex(list []int, i int) []int {
+	for j, v := range list {
+		if v == i {
+			return append(list[:j], list[j+1:]...)
+		}
+	}
+	return list
+}
+
+// overdraws reports whether b is a copy of a drawn over or just next to it.
+func overdraws(a, b glyph) bool {
+	if a.S != b.S || a.Font != b.Font || math.Abs(a.FontSize-b.FontSize) > a.FontSize*0.05 {
+		return false
+	}
+	limit := a.FontSize * overdrawShift
+	if adv := glyphAdvance(a); adv > 0 {
+		limit = math.Min(limit, adv*overdrawAdvance)
+	}
+	return math.Abs(a.X-b.X) <= limit && math.Abs(a.Y-b.Y) <= limit
+}
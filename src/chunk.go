@@ -0,0 +1,258 @@
+package yapp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkOptions bounds the size of the chunks returned by ChunkDocument.
+// When both limits are zero, chunks are kept under 512 tokens.
+type ChunkOptions struct {
+	MaxTokens int
+	MaxChars  int
+	// CountTokens measures a chunk for MaxTokens. The default estimate is
+	// one token per four bytes, which is close enough for English text and
+	// most embedding tokenizers; plug in the real tokenizer when it matters.
+	CountTokens func(string) int
+	// Flavor is the Markdown dialect of the chunk text.
+	Flavor MarkdownFlavor
+}
+
+// Chunk is a piece of the document sized for an embedding model, together
+// with where it came from.
+type Chunk struct {
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+	// HeadingPath lists the headings the chunk sits under, outermost first.
+	HeadingPath []string `json:"headingPath,omitempty"`
+	Pages       []int    `json:"pages"`
+	BBoxes      []BBox   `json:"bboxes"`
+	Tokens      int      `json:"tokens"`
+}
+
+// BBox is a rectangle on a page in page space (see Position): points,
+// origin at the bottom-left corner of the page as displayed.
+type BBox struct {
+	Page int     `json:"page"`
+	X0   float64 `json:"x0"`
+	Y0   float64 `json:"y0"`
+	X1   float64 `json:"x1"`
+	Y1   float64 `json:"y1"`
+}
+
+// Chunks splits the parsed document into chunks; see ChunkDocument.
+func (r Result) Chunks(opts ChunkOptions) []Chunk {
+	return ChunkDocument(r.AST, opts)
+}
+
+// ChunkDocument splits a document into chunks that fit the budget in opts.
+// Chunks never cross a heading, and a table or list item is never split;
+// a table or item that is bigger than the budget on its own becomes a chunk
+// of its own. Paragraphs that do not fit are split between lines, and
+// between words as a last resort. A heading always stays with the content
+// that follows it.
+func ChunkDocument(doc DocumentNode, opts ChunkOptions) []Chunk {
+	c := chunker{opts: opts}
+	if c.opts.MaxTokens == 0 && c.opts.MaxChars == 0 {
+		c.opts.MaxTokens = 512
+	}
+	if c.opts.CountTokens == nil {
+		c.opts.CountTokens = estimateTokens
+	}
+
+	pages := structureDocument(doc)
+	for _, page := range pages {
+		for _, el := range page.elements {
+			c.add(el)
+		}
+	}
+	// Footnotes come last, as in the Markdown, outside any section.
+	c.flush()
+	c.path = nil
+	for _, page := range pages {
+		for _, note := range page.footnotes {
+			c.add(note)
+		}
+	}
+	c.flush()
+	return c.chunks
+}
+
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// chunkUnit is the smallest piece of text the chunker places.
+type chunkUnit struct {
+	text  string
+	sep   string // joins the unit to the one before it in the same chunk
+	boxes []BBox
+}
+
+type chunker struct {
+	opts   ChunkOptions
+	path   []string
+	units  []chunkUnit
+	text   string
+	header bool // units holds nothing but a heading so far
+	chunks []Chunk
+}
+
+func (c *chunker) add(el element) {
+	switch el.kind {
+	case elemHeading:
+		c.flush()
+		// Level n replaces everything at level n and below.
+		if keep := el.level - 1; keep < len(c.path) {
+			c.path = c.path[:keep]
+		}
+		c.path = append(c.path, applyFlavor(el.parts[0], c.opts.Flavor))
+		c.place(chunkUnit{text: el.markdown(c.opts.Flavor), boxes: lineBoxes(el.lines)})
+		c.header = true
+	case elemList:
+		for i, item := range el.parts {
+			sep := "\n"
+			if i == 0 {
+				sep = "\n\n"
+			}
+			c.place(chunkUnit{text: "- " + item, sep: sep, boxes: lineBoxes(el.lines[i : i+1])})
+		}
+	case elemTable, elemAside, elemFootnote, elemCode:
+		c.place(chunkUnit{text: el.markdown(c.opts.Flavor), sep: "\n\n", boxes: lineBoxes(el.lines)})
+	default:
+		for _, u := range c.splitParagraph(el) {
+			c.place(u)
+		}
+	}
+}
+
+// place appends a unit to the open chunk, starting a new one first when the
+// unit would push it over budget.
+func (c *chunker) place(u chunkUnit) {
+	if len(c.units) > 0 && !c.header && !c.fits(c.text+u.sep+u.text) {
+		c.flush()
+	}
+	if len(c.units) == 0 {
+		c.text = u.text
+	} else {
+		c.text += u.sep + u.text
+	}
+	c.units = append(c.units, u)
+	c.header = false
+}
+
+func (c *chunker) flush() {
+	if len(c.units) == 0 {
+		return
+	}
+	var boxes []BBox
+	for _, u := range c.units {
+		boxes = append(boxes, u.boxes...)
+	}
+	c.chunks = append(c.chunks, Chunk{
+		Index:       len(c.chunks),
+		Text:        c.text,
+		HeadingPath: append([]string(nil), c.path...),
+		Pages:       boxPages(boxes),
+		BBoxes:      boxes,
+		Tokens:      c.opts.CountTokens(c.text),
+	})
+	c.units, c.text, c.header = nil, "", false
+}
+
+func (c *chunker) fits(s string) bool {
+	if c.opts.MaxChars > 0 && utf8.RuneCountInString(s) > c.opts.MaxChars {
+		return false
+	}
+	if c.opts.MaxTokens > 0 && c.opts.CountTokens(s) > c.opts.MaxTokens {
+		return false
+	}
+	return true
+}
+
+// splitParagraph cuts a paragraph into units that each fit the budget,
+// breaking between lines where possible.
+func (c *chunker) splitParagraph(el element) []chunkUnit {
+	text := el.markdown(c.opts.Flavor)
+	if c.fits(text) {
+		return []chunkUnit{{text: text, sep: "\n\n", boxes: lineBoxes(el.lines)}}
+	}
+
+	var units []chunkUnit
+	var cur []string
+	var curLines []lineStyle
+	emit := func() {
+		if len(cur) == 0 {
+			return
+		}
+		sep := " "
+		if len(units) == 0 {
+			sep = "\n\n"
+		}
+		units = append(units, chunkUnit{text: joinText(cur), sep: sep, boxes: lineBoxes(curLines)})
+		cur, curLines = nil, nil
+	}
+	for i, part := range el.parts {
+		line := el.lines[i]
+		if len(cur) > 0 && !c.fits(joinText(append(cur, part))) {
+			emit()
+		}
+		if c.fits(part) {
+			cur = append(cur, part)
+			curLines = append(curLines, line)
+			continue
+		}
+		// A single line over budget: fall back to words.
+		emit()
+		for _, word := range strings.Fields(part) {
+			if len(cur) > 0 && !c.fits(joinText(append(cur, word))) {
+				emit()
+			}
+			cur = append(cur, word)
+			curLines = []lineStyle{line}
+		}
+		emit()
+	}
+	emit()
+	return units
+}
+
+// lineBoxes returns one box per page covering the given lines.
+func lineBoxes(lines []lineStyle) []BBox {
+	var boxes []BBox
+	for _, line := range lines {
+		for _, span := range line.spans {
+			p := span.Pos
+			x0, y0, x1, y1 := p.X0, p.Y0, p.X1, p.Y1
+			if x1 <= x0 && y1 <= y0 {
+				// No glyph geometry: assume the font size above the baseline.
+				x0, y0, x1, y1 = p.X, p.Y, p.X+p.Width, p.Y+p.FontSize
+			}
+			i := len(boxes) - 1
+			for ; i >= 0 && boxes[i].Page != p.Page; i-- {
+			}
+			if i < 0 {
+				boxes = append(boxes, BBox{Page: p.Page, X0: x0, Y0: y0, X1: x1, Y1: y1})
+				continue
+			}
+			b := &boxes[i]
+			b.X0, b.Y0 = min(b.X0, x0), min(b.Y0, y0)
+			b.X1, b.Y1 = max(b.X1, x1), max(b.Y1, y1)
+		}
+	}
+	return boxes
+}
+
+func boxPages(boxes []BBox) []int {
+	var pages []int
+	seen := make(map[int]bool)
+	for _, b := range boxes {
+		if !seen[b.Page] {
+			seen[b.Page] = true
+			pages = append(pages, b.Page)
+		}
+	}
+	sort.Ints(pages)
+	return pages
+}
@@ -0,0 +1,132 @@
+package yapp
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLine builds a line of one span per word, 5pt per character.
+func testLine(page int, y, size float64, text string) LineNode {
+	var line LineNode
+	x := 72.0
+	for _, w := range strings.Fields(text) {
+		width := float64(len(w)) * 5
+		line.Spans = append(line.Spans, TextSpan{Text: w, Pos: Position{Page: page, X: x, Y: y, Width: width, FontSize: size}})
+		x += width + 5
+	}
+	return line
+}
+
+func chunkTestDoc() DocumentNode {
+	page := func(n int, lines ...LineNode) PageNode {
+		blocks := make([]BlockNode, len(lines))
+		for i, l := range lines {
+			blocks[i] = BlockNode{Lines: []LineNode{l}}
+		}
+		return PageNode{Number: n, Blocks: blocks}
+	}
+	return DocumentNode{Pages: []PageNode{
+		page(1,
+			testLine(1, 700, 24, "Handbook"),
+			testLine(1, 660, 18, "Getting Started"),
+			testLine(1, 640, 10, "first paragraph line one goes here"),
+			testLine(1, 628, 10, "and the paragraph continues here"),
+			testLine(1, 610, 10, "- install the tool"),
+			testLine(1, 598, 10, "- run the tool"),
+		),
+		page(2,
+			testLine(2, 700, 18, "Reference Notes"),
+			testLine(2, 680, 10, "closing words for the reference part"),
+		),
+	}}
+}
+
+func TestChunkHeadingPathsAndProvenance(t *testing.T) {
+	chunks := ChunkDocument(chunkTestDoc(), ChunkOptions{})
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3: %+v", len(chunks), chunks)
+	}
+
+	wantPaths := [][]string{{"Handbook"}, {"Handbook", "Getting Started"}, {"Handbook", "Reference Notes"}}
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		if !reflect.DeepEqual(c.HeadingPath, wantPaths[i]) {
+			t.Errorf("chunk %d heading path = %q, want %q", i, c.HeadingPath, wantPaths[i])
+		}
+	}
+
+	body := chunks[1]
+	if !strings.HasPrefix(body.Text, "## Getting Started\n\nfirst paragraph") || !strings.HasSuffix(body.Text, "- install the tool\n- run the tool") {
+		t.Errorf("unexpected chunk text %q", body.Text)
+	}
+	if !reflect.DeepEqual(chunks[2].Pages, []int{2}) {
+		t.Errorf("pages = %v, want [2]", chunks[2].Pages)
+	}
+	// heading, paragraph and two list items
+	if len(body.BBoxes) != 4 {
+		t.Fatalf("got %d bboxes, want 4: %+v", len(body.BBoxes), body.BBoxes)
+	}
+	para := body.BBoxes[1]
+	if para.Page != 1 || para.X0 != 72 || para.Y0 != 628 || para.Y1 != 650 {
+		t.Errorf("paragraph bbox = %+v", para)
+	}
+}
+
+func TestChunkBudget(t *testing.T) {
+	chunks := ChunkDocument(chunkTestDoc(), ChunkOptions{MaxChars: 60})
+	var items int
+	for _, c := range chunks {
+		// The only chunk allowed over budget is a heading with its first unit.
+		if len([]rune(c.Text)) > 60 && !strings.HasPrefix(c.Text, "#") {
+			t.Errorf("chunk over budget: %q", c.Text)
+		}
+		for _, line := range strings.Split(c.Text, "\n") {
+			if strings.HasPrefix(line, "- ") {
+				items++
+				if line != "- install the tool" && line != "- run the tool" {
+					t.Errorf("list item split: %q", line)
+				}
+			}
+		}
+	}
+	if items != 2 {
+		t.Errorf("found %d list items, want 2", items)
+	}
+	if chunks[1].Text != "## Getting Started\n\nfirst paragraph line one goes here" {
+		t.Errorf("paragraph not split between lines: %q", chunks[1].Text)
+	}
+}
+
+func TestChunkRecordsJSONL(t *testing.T) {
+	chunks := ChunkDocument(chunkTestDoc(), ChunkOptions{})
+	var first, second strings.Builder
+	for _, b := range []*strings.Builder{&first, &second} {
+		if err := WriteChunksJSONL(b, ChunkRecords("doc.pdf", "0123456789abcdef0123", chunks)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if first.String() != second.String() {
+		t.Fatal("export is not deterministic")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(first.String(), "\n"), "\n")
+	if len(lines) != len(chunks) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(chunks))
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for _, key := range []string{"id", "text", "heading_path", "pages", "bboxes", "source", "sha256"} {
+		if _, ok := rec[key]; !ok {
+			t.Errorf("record lacks %q: %s", key, lines[1])
+		}
+	}
+	if rec["id"] != "0123456789abcdef-0001" || rec["source"] != "doc.pdf" {
+		t.Errorf("unexpected id/source in %s", lines[1])
+	}
+}
@@ -0,0 +1,62 @@
+package yapp
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isCJK reports whether r belongs to the scripts written without spaces
+// between words: Chinese ideographs, Japanese kana and Bopomofo. Korean
+// separates words with spaces and is left out.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Bopomofo) ||
+		r == 'ー' || isWidePunctuation(r)
+}
+
+// isWidePunctuation reports whether r is CJK or full-width punctuation,
+// such as 、。「」（）！？ and the ideographic space.
+func isWidePunctuation(r rune) bool {
+	switch {
+	case r >= 0x3000 && r <= 0x303F: // CJK Symbols and Punctuation
+		return true
+	case r >= 0xFE30 && r <= 0xFE4F: // CJK Compatibility Forms
+		return true
+	case r >= 0xFF00 && r <= 0xFF65: // Halfwidth and Fullwidth Forms
+		return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+	}
+	return false
+}
+
+// joinsTight reports whether next follows prev without a space: text
+// written without word spaces does not get any between its tokens, nor
+// between lines when a paragraph wraps, and neither do the halves of a
+// word broken at a soft hyphen.
+func joinsTight(prev, next string) bool {
+	if strings.HasSuffix(prev, softHyphen) {
+		return true
+	}
+	last, _ := utf8.DecodeLastRuneInString(prev)
+	first, _ := utf8.DecodeRuneInString(next)
+	if last == utf8.RuneError || first == utf8.RuneError {
+		return false
+	}
+	if isWidePunctuation(last) || isWidePunctuation(first) {
+		return true
+	}
+	return isCJK(last) && isCJK(first)
+}
+
+// joinText joins the lines of a paragraph with spaces, except where the
+// text on either side is written without them, and drops the soft hyphens
+// of broken words.
+func joinText(parts []string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 && !joinsTight(parts[i-1], part) {
+			b.WriteString(" ")
+		}
+		b.WriteString(part)
+	}
+	return strings.ReplaceAll(b.String(), softHyphen, "")
+}
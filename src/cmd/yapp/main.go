@@ -1,29 +1,183 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	yapp "github.com/bentor/yapp"
 )
 
 func main() {
-	var inPath, outPath string
-	var debug bool
-	flag.StringVar(&inPath, "in", "", "input PDF file")
-	flag.StringVar(&outPath, "out", "", "output Markdown file")
-	flag.BoolVar(&debug, "debug", false, "pretty-print the AST to stdout")
-	flag.Parse()
-
-	if inPath == "" || outPath == "" {
-		fmt.Fprintf(os.Stderr, "usage: %s --in input.pdf --out output.md\n", os.Args[0])
-		flag.PrintDefaults()
+	if len(os.Args) > 1 && os.Args[1] == "embed" {
+		embed(os.Args[2:])
+		return
+	}
+	convert(os.Args[1:])
+}
+
+// parseFlags are the flags shared by every mode that parses a PDF.
+type parseFlags struct {
+	inPath, outPath, invisible, ocrLang, password, passwordFile, flavor, normalize, pages string
+	ocr, ocrGarbled, lenient, keepWatermarks, keepArtifacts, keepOffPage                  bool
+	maxTokens, maxChars                                                                   int
+}
+
+func (p *parseFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.inPath, "in", "", "input PDF file")
+	fs.StringVar(&p.outPath, "out", "", "output file")
+	fs.StringVar(&p.invisible, "invisible", "auto", "invisible text: auto, include (OCR'd scans) or exclude (born-digital)")
+	fs.BoolVar(&p.ocr, "ocr", false, "run tesseract on pages without a text layer")
+	fs.BoolVar(&p.ocrGarbled, "ocr-garbled", false, "with --ocr, also OCR pages whose fonts decode to garbage")
+	fs.StringVar(&p.ocrLang, "ocr-lang", "eng", "tesseract language(s), e.g. eng+deu")
+	fs.StringVar(&p.password, "password", "", "user password for encrypted PDFs")
+	fs.StringVar(&p.passwordFile, "password-file", "", "read the user password from this file")
+	fs.StringVar(&p.pages, "pages", "", "pages to parse, e.g. 1-3,7,10- (default all)")
+	fs.BoolVar(&p.lenient, "lenient", false, "skip pages that fail to decode instead of aborting")
+	fs.BoolVar(&p.keepWatermarks, "keep-watermarks", false, "keep watermark and background text instead of dropping it")
+	fs.BoolVar(&p.keepOffPage, "keep-off-page", false, "keep text outside the page's crop box (printer's marks, slugs)")
+	fs.BoolVar(&p.keepArtifacts, "keep-artifacts", false, "keep text tagged as artifacts (headers, footers, page numbers)")
+	fs.StringVar(&p.flavor, "flavor", "commonmark", "Markdown flavor: commonmark (<sup>, <sub>) or pandoc (^sup^, ~sub~)")
+	fs.StringVar(&p.normalize, "normalize", "nfc", "Unicode normalization: nfc, nfkc (also folds full-width and compatibility forms) or none")
+	fs.IntVar(&p.maxTokens, "max-tokens", 0, "chunks: token budget per chunk (default 512 when no budget is set)")
+	fs.IntVar(&p.maxChars, "max-chars", 0, "chunks: character budget per chunk")
+}
+
+// options validates the flags and builds the parse options, exiting on
+// bad input.
+func (p *parseFlags) options(fs *flag.FlagSet, usage string) yapp.Options {
+	if p.inPath == "" || p.outPath == "" {
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], usage)
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var opts yapp.Options
+	switch p.invisible {
+	case "auto":
+		opts.InvisibleText = yapp.InvisibleAuto
+	case "include":
+		opts.InvisibleText = yapp.InvisibleInclude
+	case "exclude":
+		opts.InvisibleText = yapp.InvisibleExclude
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --invisible mode %q\n", p.invisible)
+		os.Exit(1)
+	}
+
+	switch p.flavor {
+	case "commonmark":
+		opts.Flavor = yapp.FlavorCommonMark
+	case "pandoc":
+		opts.Flavor = yapp.FlavorPandoc
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --flavor %q\n", p.flavor)
 		os.Exit(1)
 	}
 
-	if err := yapp.Run(inPath, outPath, debug); err != nil {
-		fmt.Fprintf(os.Stderr, "yapp failed: %v\n", err)
+	switch p.normalize {
+	case "nfc":
+		opts.Normalization = yapp.NormalizeNFC
+	case "nfkc":
+		opts.Normalization = yapp.NormalizeNFKC
+	case "none":
+		opts.Normalization = yapp.NormalizeNone
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --normalize %q\n", p.normalize)
+		os.Exit(1)
+	}
+
+	if p.pages != "" {
+		sel, err := yapp.ParsePageSelection(p.pages)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--pages: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Pages = sel
+	}
+
+	opts.Lenient = p.lenient
+	opts.KeepWatermarks = p.keepWatermarks
+	opts.KeepArtifacts = p.keepArtifacts
+	opts.KeepOffPage = p.keepOffPage
+	opts.Password = p.password
+	if p.passwordFile != "" {
+		data, err := os.ReadFile(p.passwordFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read password file: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if p.ocr {
+		opts.OCR = yapp.TesseractOCR{Languages: p.ocrLang}
+		opts.OCRGarbled = p.ocrGarbled
+	}
+	return opts
+}
+
+func (p *parseFlags) chunkOptions(opts yapp.Options) yapp.ChunkOptions {
+	return yapp.ChunkOptions{MaxTokens: p.maxTokens, MaxChars: p.maxChars, Flavor: opts.Flavor}
+}
+
+func convert(args []string) {
+	fs := flag.NewFlagSet("yapp", flag.ExitOnError)
+	var p parseFlags
+	var format string
+	var debug bool
+	p.register(fs)
+	fs.StringVar(&format, "format", "markdown", "output format: markdown or jsonl-chunks")
+	fs.BoolVar(&debug, "debug", false, "pretty-print the AST to stdout")
+	fs.Parse(args)
+	opts := p.options(fs, "--in input.pdf --out output.md [--format jsonl-chunks]")
+
+	var result yapp.Result
+	var err error
+	switch format {
+	case "markdown":
+		result, err = yapp.RunWithOptions(p.inPath, p.outPath, debug, opts)
+	case "jsonl-chunks":
+		result, err = yapp.RunChunks(p.inPath, p.outPath, opts, p.chunkOptions(opts))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --format %q\n", format)
 		os.Exit(1)
 	}
+	exitOnError(err)
+	fmt.Fprint(os.Stderr, result.Summary())
+}
+
+func embed(args []string) {
+	fs := flag.NewFlagSet("yapp embed", flag.ExitOnError)
+	var p parseFlags
+	var e yapp.OllamaEmbedder
+	p.register(fs)
+	fs.StringVar(&e.URL, "url", "http://localhost:11434/api/embeddings", "Ollama-compatible embeddings endpoint")
+	fs.StringVar(&e.Model, "model", "nomic-embed-text", "embedding model")
+	fs.IntVar(&e.BatchSize, "batch", 1, "texts per request; above 1 the endpoint must accept an input array, like /api/embed")
+	fs.IntVar(&e.Concurrency, "concurrency", 4, "requests in flight")
+	fs.IntVar(&e.Retries, "retries", 3, "retries per request on network errors, 429 and 5xx")
+	fs.Parse(args)
+	opts := p.options(fs, "embed --in input.pdf --out chunks.jsonl [--url URL] [--model name]")
+
+	if e.Retries == 0 {
+		e.Retries = -1
+	}
+	result, err := yapp.RunEmbed(context.Background(), p.inPath, p.outPath, opts, p.chunkOptions(opts), e)
+	exitOnError(err)
+	fmt.Fprint(os.Stderr, result.Summary())
+}
+
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "yapp failed: %v\n", err)
+	if errors.Is(err, yapp.ErrPasswordRequired) {
+		fmt.Fprintln(os.Stderr, "the PDF is encrypted; pass --password or --password-file")
+	}
+	os.Exit(1)
 }
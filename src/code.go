@@ -0,0 +1,138 @@
+package yapp
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isCodeSpan reports whether a span reads as code: set in a monospaced
+// font and free of emoji and CJK, whose fonts are fixed-pitch too.
+func isCodeSpan(span TextSpan) bool {
+	if !span.Pos.Monospace {
+		return false
+	}
+	for _, r := range span.Text {
+		if unicode.Is(unicode.So, r) || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return false
+		}
+	}
+	return true
+}
+
+// typewritten reports whether a document is set in monospaced fonts
+// throughout, as typewritten documents and plain-text dumps are. Its text
+// is prose whatever its pitch. Any proportional text, even a lone caption,
+// means the monospaced text is code.
+func typewritten(doc DocumentNode) bool {
+	mono := false
+	for _, page := range doc.Pages {
+		for _, block := range page.Blocks {
+			for _, line := range block.Lines {
+				for _, span := range line.Spans {
+					if strings.TrimSpace(span.Text) == "" {
+						continue
+					}
+					if !span.Pos.Monospace {
+						return false
+					}
+					mono = true
+				}
+			}
+		}
+	}
+	return mono
+}
+
+// proseSpans returns spans with none of them marked monospaced.
+func proseSpans(spans []TextSpan) []TextSpan {
+	out := append([]TextSpan(nil), spans...)
+	for i := range out {
+		out[i].Pos.Monospace = false
+	}
+	return out
+}
+
+// isCodeLine reports whether every span on a line is code.
+func isCodeLine(line lineStyle) bool {
+	if len(line.spans) == 0 {
+		return false
+	}
+	for _, span := range line.spans {
+		if !isCodeSpan(span) {
+			return false
+		}
+	}
+	return true
+}
+
+// inlineCode wraps text in a code span, using a longer fence when the text
+// itself contains backticks.
+func inlineCode(text string) string {
+	if strings.Contains(text, "`") {
+		return "`` " + text + " ``"
+	}
+	return "`" + text + "`"
+}
+
+// codeBlock lays out a run of code lines as text. Indentation and the gaps
+// between words are rebuilt from span X positions in units of the font's
+// character width, so aligned columns stay aligned. A vertical gap wider
+// than a line becomes a blank line. The returned lines match the returned
+// source lines one to one; blank lines have an empty source line.
+func codeBlock(lines []lineStyle) ([]string, []lineStyle) {
+	minX := math.Inf(1)
+	var widths []float64
+	for _, line := range lines {
+		for _, span := range line.spans {
+			minX = math.Min(minX, span.Pos.X)
+			if n := utf8.RuneCountInString(span.Text); n > 0 && span.Pos.Width > 0 {
+				widths = append(widths, span.Pos.Width/float64(n))
+			}
+		}
+	}
+	charW := 0.0
+	if len(widths) > 0 {
+		sort.Float64s(widths)
+		charW = widths[len(widths)/2]
+	}
+	if charW <= 0 {
+		charW = lines[0].fontSize * missingWidthScale
+	}
+
+	var out []string
+	var src []lineStyle
+	for i, line := range lines {
+		if i > 0 && lines[i-1].y-line.y > 1.8*math.Max(line.fontSize, lines[i-1].fontSize) {
+			out = append(out, "")
+			src = append(src, lineStyle{})
+		}
+		var b strings.Builder
+		col := 0
+		for _, span := range line.spans {
+			want := int(math.Round((span.Pos.X - minX) / charW))
+			if col > 0 && want <= col {
+				want = col + 1
+			}
+			b.WriteString(strings.Repeat(" ", max(want-col, 0)))
+			b.WriteString(span.Text)
+			col = max(want, col) + utf8.RuneCountInString(span.Text)
+		}
+		out = append(out, b.String())
+		src = append(src, line)
+	}
+	return out, src
+}
+
+// codeFence returns a fence longer than any backtick run in the code.
+func codeFence(code []string) string {
+	fence := "```"
+	for _, line := range code {
+		for strings.Contains(line, fence) {
+			fence += "`"
+		}
+	}
+	return fence
+}
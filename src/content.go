@@ -0,0 +1,696 @@
+package yapp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// glyph is a single decoded character plus the graphics state it was drawn
+// with. The embedded pdf.Text carries the same values pdf.Page.Content
+// reports; the remaining fields are state that library throws away.
+type glyph struct {
+	pdf.Text
+	mode    int  // text render mode (Tr)
+	fill    rgb  // non-stroking colour
+	clipped bool // glyph lies outside the active clipping path
+	ocr     bool // recognised by an OCREngine rather than decoded
+	// rawFill is set when the fill colour space is neither a device space
+	// nor ICCBased, so fill holds its raw values rather than a colour.
+	rawFill bool
+	// baseFont is the font's BaseFont with its subset prefix, which tells
+	// apart subsets of one font that Font, the bare name, does not.
+	baseFont string
+	// box spans the advance width and the font's descent to ascent.
+	box       bbox
+	baseline  float64         // Y of the baseline, before text rise
+	rotation  float64         // degrees counter-clockwise
+	charSpace float64         // Tc in user space units
+	script    Script          // set by markScripts
+	mono      bool            // set in a fixed-pitch font
+	vertical  bool            // set in vertical writing mode
+	bold      bool            // drawn several times over; see collapseOverdraw
+	alpha     float64         // fill opacity (ca)
+	marked    []markedContent // enclosing marked-content sequences, outermost first
+	watermark bool            // set by markWatermarks
+	footer    bool            // running footer, set by markFooters
+	offPage   bool            // set by markOffPage
+	element   int             // structure element, from the glyph's MCID
+}
+
+// invisible reports whether the glyph is never painted on the page.
+func (g glyph) invisible() bool {
+	return g.mode == 3 || g.mode == 7 || g.clipped
+}
+
+type rgb struct {
+	r, g, b float64
+}
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", colorByte(c.r), colorByte(c.g), colorByte(c.b))
+}
+
+// luminance is the relative luminance of the colour, 0 for black and 1
+// for white.
+func (c rgb) luminance() float64 {
+	return 0.2126*c.r + 0.7152*c.g + 0.0722*c.b
+}
+
+func colorByte(v float64) int {
+	return int(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func cmykToRGB(c, m, y, k float64) rgb {
+	return rgb{(1 - c) * (1 - k), (1 - m) * (1 - k), (1 - y) * (1 - k)}
+}
+
+type matrix [3][3]float64
+
+var identity = matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+func (x matrix) mul(y matrix) matrix {
+	var z matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				z[i][j] += x[i][k] * y[k][j]
+			}
+		}
+	}
+	return z
+}
+
+func (x matrix) apply(px, py float64) (float64, float64) {
+	return px*x[0][0] + py*x[1][0] + x[2][0], px*x[0][1] + py*x[1][1] + x[2][1]
+}
+
+// inverse returns the matrix undoing x, and false when x is singular.
+func (x matrix) inverse() (matrix, bool) {
+	a, b, c, d, e, f := x[0][0], x[0][1], x[1][0], x[1][1], x[2][0], x[2][1]
+	det := a*d - b*c
+	if det == 0 {
+		return matrix{}, false
+	}
+	return matrix{
+		{d / det, -b / det, 0},
+		{-c / det, a / det, 0},
+		{(c*f - d*e) / det, (b*e - a*f) / det, 1},
+	}, true
+}
+
+func translate(tx, ty float64) matrix {
+	return matrix{{1, 0, 0}, {0, 1, 0}, {tx, ty, 1}}
+}
+
+func matrixFromArgs(args []pdf.Value) matrix {
+	var m matrix
+	for i := 0; i < 6; i++ {
+		m[i/2][i%2] = args[i].Float64()
+	}
+	m[2][2] = 1
+	return m
+}
+
+// bbox is an axis-aligned rectangle in device space.
+type bbox struct {
+	x0, y0, x1, y1 float64
+}
+
+func (b bbox) extend(x, y float64) bbox {
+	return bbox{math.Min(b.x0, x), math.Min(b.y0, y), math.Max(b.x1, x), math.Max(b.y1, y)}
+}
+
+func (b bbox) union(o bbox) bbox {
+	return b.extend(o.x0, o.y0).extend(o.x1, o.y1)
+}
+
+func (b bbox) intersect(o bbox) bbox {
+	return bbox{math.Max(b.x0, o.x0), math.Max(b.y0, o.y0), math.Min(b.x1, o.x1), math.Min(b.y1, o.y1)}
+}
+
+func (b bbox) contains(x, y, slack float64) bool {
+	return x >= b.x0-slack && x <= b.x1+slack && y >= b.y0-slack && y <= b.y1+slack
+}
+
+type graphicsState struct {
+	ctm      matrix
+	tm       matrix
+	tlm      matrix
+	tc       float64
+	tw       float64
+	th       float64
+	tl       float64
+	tfs      float64
+	trise    float64
+	mode     int
+	font     *fontState
+	fill     rgb
+	tintFill bool    // fill colour space is Separation/DeviceN (tint values)
+	rawFill  bool    // fill colour space is not Device* or ICCBased; see glyph.rawFill
+	alpha    float64 // fill opacity, from the ExtGState's /ca
+	clip     bbox
+	hasClip  bool
+}
+
+type fontState struct {
+	font pdf.Font
+	enc  pdf.TextEncoding
+	name string
+	// ascent and descent as fractions of the em, from the font descriptor.
+	ascent, descent float64
+	baseFont        string // BaseFont as given, subset prefix and all
+	mono            bool   // fixed-pitch font
+	vertical        bool   // vertical writing mode (an Identity-V style CMap)
+}
+
+// Font metrics assumed when the font has no usable descriptor, as is the
+// case for the standard 14 fonts.
+const (
+	defaultAscent  = 0.75
+	defaultDescent = -0.25
+)
+
+// verticalOriginY is how far, in ems, the origin of a glyph in vertical
+// writing sits above the origin it would have in horizontal writing: the
+// default DW2 position vector. Horizontally it sits at the glyph's centre.
+const verticalOriginY = 0.88
+
+// monoFontNames are common monospaced font families, as the words
+// fontWords splits their names into.
+var monoFontNames = []string{
+	"courier", "consolas", "menlo", "monaco", "inconsolata", "typewriter",
+	"lucida console", "letter gothic", "andale mono", "source code",
+	"fira code", "fira mono", "deja vu sans mono", "liberation mono",
+	"roboto mono", "ubuntu mono", "jet brains mono", "ibm plex mono", "sf mono",
+}
+
+// fontWords splits a font name into lowercase words at separators, digits
+// and case changes: "CourierNewPS-BoldMT" gives "courier new ps bold mt".
+func fontWords(name string) string {
+	var words []string
+	runes := []rune(name)
+	start := -1
+	for i, r := range runes {
+		if !unicode.IsLetter(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+			}
+			start = -1
+			continue
+		}
+		if start >= 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return strings.ToLower(strings.Join(words, " "))
+}
+
+// isMonospaced reports whether a font is fixed-pitch: flagged so in its
+// descriptor, named like a monospaced family, or with one advance width
+// for every glyph it defines.
+func isMonospaced(font pdf.Value, name string) bool {
+	desc := font.Key("FontDescriptor")
+	if desc.IsNull() {
+		desc = font.Key("DescendantFonts").Index(0).Key("FontDescriptor")
+	}
+	if desc.Key("Flags").Int64()&1 != 0 {
+		return true
+	}
+	words := " " + fontWords(name) + " "
+	for _, n := range monoFontNames {
+		if strings.Contains(words, " "+n+" ") {
+			return true
+		}
+	}
+	widths := font.Key("Widths")
+	var width float64
+	count := 0
+	for i := 0; i < widths.Len(); i++ {
+		w := widths.Index(i).Float64()
+		if w == 0 {
+			continue
+		}
+		if count > 0 && w != width {
+			return false
+		}
+		width = w
+		count++
+	}
+	return count > 1
+}
+
+// fontMetrics reads ascent and descent from a font's descriptor, looking in
+// the descendant font for composite fonts.
+func fontMetrics(font pdf.Value) (ascent, descent float64) {
+	desc := font.Key("FontDescriptor")
+	if desc.IsNull() {
+		desc = font.Key("DescendantFonts").Index(0).Key("FontDescriptor")
+	}
+	ascent = desc.Key("Ascent").Float64() / 1000
+	descent = -math.Abs(desc.Key("Descent").Float64()) / 1000
+	if ascent <= 0 {
+		ascent = defaultAscent
+	}
+	if descent == 0 {
+		descent = defaultDescent
+	}
+	return ascent, descent
+}
+
+// contentWalker interprets a page content stream the way pdf.Page.Content
+// does, but keeps the graphics state needed to tell visible text apart.
+type contentWalker struct {
+	page   pdf.Page
+	fonts  map[string]*fontState
+	g      graphicsState
+	stack  []graphicsState
+	path   bbox
+	inPath bool
+	clipOp bool
+	glyphs []glyph
+	images []placedImage
+	marked []markedContent // open marked-content sequences; never appended to in place
+}
+
+// placedImage is an image XObject painted on a page: its resource name and
+// the CTM it was painted with, which maps the unit square onto the page.
+type placedImage struct {
+	name string
+	ctm  matrix
+}
+
+// pageContent is what readPage finds on a page.
+type pageContent struct {
+	glyphs []glyph
+	images []placedImage // image XObjects painted on the page
+	fonts  []fontInfo    // fonts text was shown in
+}
+
+// readPage looks up a page and decodes its content stream. The pdf package
+// reports malformed input by panicking; readPage turns that into an
+// ErrCorrupt error.
+func readPage(reader *pdf.Reader, number int) (page pdf.Page, content pageContent, err error) {
+	defer recoverCorrupt(&err)
+
+	page = reader.Page(number)
+	if page.V.IsNull() {
+		return page, pageContent{}, fmt.Errorf("%w: page object not found", ErrCorrupt)
+	}
+	if page.V.Key("Contents").Kind() == pdf.Null {
+		return page, pageContent{}, nil
+	}
+
+	w := &contentWalker{
+		page:  page,
+		fonts: make(map[string]*fontState),
+		g:     graphicsState{ctm: identity, tm: identity, tlm: identity, th: 1, alpha: 1},
+	}
+	pdf.Interpret(page.V.Key("Contents"), w.do)
+	content = pageContent{glyphs: w.glyphs, images: w.images}
+	for _, fs := range w.fonts {
+		content.fonts = append(content.fonts, describeFont(fs.font.V, fs.baseFont))
+	}
+	return page, content, nil
+}
+
+func (w *contentWalker) fontFor(name string) *fontState {
+	if fs, ok := w.fonts[name]; ok {
+		return fs
+	}
+	font := w.page.Font(name)
+	enc := font.Encoder()
+	if enc == nil {
+		enc = nopEncoding{}
+	}
+	base := font.BaseFont()
+	if i := strings.Index(base, "+"); i >= 0 {
+		base = base[i+1:]
+	}
+	fs := &fontState{font: font, enc: enc, name: base, baseFont: font.BaseFont()}
+	fs.ascent, fs.descent = fontMetrics(font.V)
+	fs.mono = isMonospaced(font.V, base)
+	fs.vertical = strings.HasSuffix(font.V.Key("Encoding").Name(), "-V")
+	w.fonts[name] = fs
+	return fs
+}
+
+type nopEncoding struct{}
+
+func (nopEncoding) Decode(raw string) string { return raw }
+
+func (w *contentWalker) showText(s string) {
+	g := &w.g
+	var enc pdf.TextEncoding = nopEncoding{}
+	var font pdf.Font
+	var fontName, baseFont string
+	ascent, descent := defaultAscent, defaultDescent
+	var mono, vertical bool
+	if g.font != nil {
+		enc, font, fontName, baseFont = g.font.enc, g.font.font, g.font.name, g.font.baseFont
+		ascent, descent, mono = g.font.ascent, g.font.descent, g.font.mono
+		vertical = g.font.vertical
+	}
+
+	n := 0
+	for _, ch := range enc.Decode(s) {
+		var w0 float64
+		if n < len(s) {
+			w0 = font.Width(int(s[n]))
+		}
+		n++
+
+		trm := matrix{{g.tfs * g.th, 0, 0}, {0, g.tfs, 0}, {0, g.trise, 1}}.mul(g.tm).mul(g.ctm)
+		adv := w0 / 1000
+		if adv == 0 {
+			adv = missingWidthScale
+		}
+		cell := trm
+		if vertical {
+			cell = translate(-adv/2, -verticalOriginY).mul(trm)
+		}
+		box := glyphBox(cell, adv, ascent, descent)
+		tm := g.tm.mul(g.ctm)
+		_, baseline := tm.apply(0, 0)
+		// Font size along the text direction, so rotated text keeps its size.
+		size := math.Hypot(trm[0][0], trm[0][1])
+		advance := w0 / 1000 * size
+		if vertical {
+			advance = math.Hypot(trm[1][0], trm[1][1])
+		}
+		gl := glyph{
+			Text: pdf.Text{
+				Font:     fontName,
+				FontSize: size,
+				X:        trm[2][0],
+				Y:        trm[2][1],
+				W:        advance,
+				S:        string(ch),
+			},
+			mode:      g.mode,
+			baseFont:  baseFont,
+			fill:      g.fill,
+			rawFill:   g.rawFill,
+			box:       box,
+			baseline:  baseline,
+			rotation:  math.Atan2(trm[0][1], trm[0][0]) * 180 / math.Pi,
+			charSpace: g.tc * g.th * math.Hypot(tm[0][0], tm[0][1]),
+			mono:      mono,
+			vertical:  vertical,
+			alpha:     g.alpha,
+			marked:    w.marked,
+		}
+		if g.hasClip {
+			gl.clipped = !g.clip.contains(gl.X+gl.W/2, gl.Y, 1)
+		}
+		w.glyphs = append(w.glyphs, gl)
+
+		if vertical {
+			// Glyphs stack downwards by the default vertical advance, -1 em.
+			ty := -g.tfs + g.tc
+			if ch == ' ' {
+				ty += g.tw
+			}
+			g.tm = translate(0, ty).mul(g.tm)
+			continue
+		}
+		tx := w0/1000*g.tfs + g.tc
+		if ch == ' ' {
+			tx += g.tw
+		}
+		g.tm = translate(tx*g.th, 0).mul(g.tm)
+	}
+}
+
+// glyphBox maps a glyph cell, adv wide and spanning descent to ascent in
+// text space, through the text rendering matrix.
+func glyphBox(trm matrix, adv, ascent, descent float64) bbox {
+	x, y := trm.apply(0, descent)
+	b := bbox{x, y, x, y}
+	for _, p := range [][2]float64{{adv, descent}, {0, ascent}, {adv, ascent}} {
+		b = b.extend(trm.apply(p[0], p[1]))
+	}
+	return b
+}
+
+func (w *contentWalker) addPoint(x, y float64) {
+	dx, dy := w.g.ctm.apply(x, y)
+	if !w.inPath {
+		w.path = bbox{dx, dy, dx, dy}
+		w.inPath = true
+		return
+	}
+	w.path = w.path.extend(dx, dy)
+}
+
+// endPath runs for every path-painting operator and applies a pending W/W*.
+func (w *contentWalker) endPath() {
+	if w.clipOp && w.inPath {
+		if w.g.hasClip {
+			w.g.clip = w.g.clip.intersect(w.path)
+		} else {
+			w.g.clip = w.path
+			w.g.hasClip = true
+		}
+	}
+	w.inPath = false
+	w.clipOp = false
+}
+
+func (w *contentWalker) setFill(args []pdf.Value) {
+	var nums []float64
+	for _, a := range args {
+		if a.Kind() == pdf.Integer || a.Kind() == pdf.Real {
+			nums = append(nums, a.Float64())
+		}
+	}
+	switch len(nums) {
+	case 1:
+		v := nums[0]
+		if w.g.tintFill {
+			v = 1 - v
+		}
+		w.g.fill = rgb{v, v, v}
+	case 3:
+		w.g.fill = rgb{nums[0], nums[1], nums[2]}
+	case 4:
+		w.g.fill = cmykToRGB(nums[0], nums[1], nums[2], nums[3])
+	}
+}
+
+func (w *contentWalker) setFillSpace(name string) {
+	w.g.fill = rgb{}
+	w.g.tintFill, w.g.rawFill = false, false
+	switch name {
+	case "DeviceGray", "DeviceRGB", "DeviceCMYK":
+		return
+	case "Pattern":
+		w.g.rawFill = true
+		return
+	}
+	cs := w.page.Resources().Key("ColorSpace").Key(name)
+	switch cs.Kind() {
+	case pdf.Name:
+		switch cs.Name() {
+		case "DeviceGray", "DeviceRGB", "DeviceCMYK":
+		default:
+			w.g.rawFill = true
+		}
+	case pdf.Array:
+		switch cs.Index(0).Name() {
+		case "ICCBased":
+		case "Separation", "DeviceN":
+			w.g.tintFill, w.g.rawFill = true, true
+		default:
+			w.g.rawFill = true
+		}
+	default:
+		w.g.rawFill = true
+	}
+}
+
+func (w *contentWalker) do(stk *pdf.Stack, op string) {
+	n := stk.Len()
+	args := make([]pdf.Value, n)
+	for i := n - 1; i >= 0; i-- {
+		args[i] = stk.Pop()
+	}
+	g := &w.g
+
+	switch op {
+	case "q":
+		w.stack = append(w.stack, w.g)
+	case "Q":
+		if len(w.stack) == 0 {
+			return
+		}
+		w.g = w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+	case "cm":
+		if len(args) == 6 {
+			g.ctm = matrixFromArgs(args).mul(g.ctm)
+		}
+
+	case "m", "l":
+		if len(args) == 2 {
+			w.addPoint(args[0].Float64(), args[1].Float64())
+		}
+	case "c", "v", "y":
+		for i := 0; i+1 < len(args); i += 2 {
+			w.addPoint(args[i].Float64(), args[i+1].Float64())
+		}
+	case "re":
+		if len(args) == 4 {
+			x, y, rw, rh := args[0].Float64(), args[1].Float64(), args[2].Float64(), args[3].Float64()
+			w.addPoint(x, y)
+			w.addPoint(x+rw, y)
+			w.addPoint(x, y+rh)
+			w.addPoint(x+rw, y+rh)
+		}
+	case "W", "W*":
+		w.clipOp = true
+	case "n", "S", "s", "f", "F", "f*", "B", "B*", "b", "b*":
+		w.endPath()
+
+	case "g", "rg", "k":
+		g.tintFill, g.rawFill = false, false
+		w.setFill(args)
+	case "cs":
+		if len(args) == 1 {
+			w.setFillSpace(args[0].Name())
+		}
+	case "sc", "scn":
+		w.setFill(args)
+
+	case "gs":
+		if len(args) == 1 {
+			ca := w.page.Resources().Key("ExtGState").Key(args[0].Name()).Key("ca")
+			if k := ca.Kind(); k == pdf.Real || k == pdf.Integer {
+				g.alpha = ca.Float64()
+			}
+		}
+
+	case "BMC", "BDC":
+		if len(args) == 0 {
+			return
+		}
+		mc := markedContent{tag: args[0].Name()}
+		if len(args) == 2 {
+			mc.props = args[1]
+			if mc.props.Kind() == pdf.Name {
+				mc.props = w.page.Resources().Key("Properties").Key(mc.props.Name())
+			}
+		}
+		w.marked = append(w.marked[:len(w.marked):len(w.marked)], mc)
+	case "EMC":
+		if len(w.marked) > 0 {
+			w.marked = w.marked[:len(w.marked)-1]
+		}
+
+	case "Do":
+		if len(args) == 1 {
+			x := w.page.Resources().Key("XObject").Key(args[0].Name())
+			if x.Key("Subtype").Name() == "Image" {
+				w.images = append(w.images, placedImage{name: args[0].Name(), ctm: g.ctm})
+			}
+		}
+
+	case "BT":
+		g.tm = identity
+		g.tlm = identity
+	case "T*":
+		g.tlm = translate(0, -g.tl).mul(g.tlm)
+		g.tm = g.tlm
+	case "Tc":
+		if len(args) == 1 {
+			g.tc = args[0].Float64()
+		}
+	case "TD", "Td":
+		if len(args) != 2 {
+			return
+		}
+		if op == "TD" {
+			g.tl = -args[1].Float64()
+		}
+		g.tlm = translate(args[0].Float64(), args[1].Float64()).mul(g.tlm)
+		g.tm = g.tlm
+	case "Tf":
+		if len(args) != 2 {
+			return
+		}
+		g.font = w.fontFor(args[0].Name())
+		g.tfs = args[1].Float64()
+	case "\"":
+		if len(args) != 3 {
+			return
+		}
+		g.tw = args[0].Float64()
+		g.tc = args[1].Float64()
+		args = args[2:]
+		fallthrough
+	case "'":
+		if len(args) != 1 {
+			return
+		}
+		g.tlm = translate(0, -g.tl).mul(g.tlm)
+		g.tm = g.tlm
+		fallthrough
+	case "Tj":
+		if len(args) != 1 {
+			return
+		}
+		w.showText(args[0].RawString())
+	case "TJ":
+		if len(args) != 1 {
+			return
+		}
+		v := args[0]
+		for i := 0; i < v.Len(); i++ {
+			x := v.Index(i)
+			if x.Kind() == pdf.String {
+				w.showText(x.RawString())
+				continue
+			}
+			g.tm = translate(-x.Float64()/1000*g.tfs*g.th, 0).mul(g.tm)
+		}
+		// pdf.Page.Content ends every TJ with a synthetic newline glyph;
+		// buildWords relies on it as a word break, so keep doing the same.
+		w.showText("\n")
+	case "TL":
+		if len(args) == 1 {
+			g.tl = args[0].Float64()
+		}
+	case "Tm":
+		if len(args) == 6 {
+			g.tm = matrixFromArgs(args)
+			g.tlm = g.tm
+		}
+	case "Tr":
+		if len(args) == 1 {
+			g.mode = int(args[0].Int64())
+		}
+	case "Ts":
+		if len(args) == 1 {
+			g.trise = args[0].Float64()
+		}
+	case "Tw":
+		if len(args) == 1 {
+			g.tw = args[0].Float64()
+		}
+	case "Tz":
+		if len(args) == 1 {
+			g.th = args[0].Float64() / 100
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package yapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Embedder turns texts into vectors, one per text and in the same order.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// OllamaEmbedder calls an Ollama-compatible embeddings endpoint.
+//
+// With BatchSize 1 every text is posted on its own as {"model", "prompt"},
+// which is what /api/embeddings expects. Larger batches are posted as
+// {"model", "input": [...]}, the shape of Ollama's /api/embed; point URL
+// there when batching. Either "embedding" or "embeddings" is accepted in
+// the response.
+type OllamaEmbedder struct {
+	URL         string // default "http://localhost:11434/api/embeddings"
+	Model       string
+	BatchSize   int           // texts per request; default 1
+	Concurrency int           // requests in flight; default 4
+	Retries     int           // extra attempts on network errors, 429 and 5xx; default 3, negative for none
+	Backoff     time.Duration // wait before the first retry, doubled each time; default 500ms
+	Client      *http.Client  // default http.DefaultClient
+}
+
+func (e OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	batch := max(e.BatchSize, 1)
+	concurrency := e.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	vectors := make([][]float64, len(texts))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for start := 0; start < len(texts); start += batch {
+		end := min(start+batch, len(texts))
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			got, err := e.post(ctx, texts[start:end])
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("embed texts %d-%d: %w", start, end-1, err)
+					cancel()
+				})
+				return
+			}
+			copy(vectors[start:end], got)
+		}(start, end)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// post sends one batch, retrying transient failures.
+func (e OllamaEmbedder) post(ctx context.Context, texts []string) ([][]float64, error) {
+	body := map[string]any{"model": e.Model}
+	if e.BatchSize <= 1 {
+		body["prompt"] = texts[0]
+	} else {
+		body["input"] = texts
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := e.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	backoff := e.Backoff
+	if backoff == 0 {
+		backoff = 500 * time.Millisecond
+	}
+
+	for attempt := 0; ; attempt++ {
+		vectors, retry, err := e.postOnce(ctx, payload)
+		if err == nil {
+			if len(vectors) != len(texts) {
+				return nil, fmt.Errorf("got %d embeddings for %d texts", len(vectors), len(texts))
+			}
+			return vectors, nil
+		}
+		if !retry || attempt >= retries {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff << attempt):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (e OllamaEmbedder) postOnce(ctx context.Context, payload []byte) (vectors [][]float64, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orDefault(e.URL, "http://localhost:11434/api/embeddings"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var out struct {
+		Embedding  []float64   `json:"embedding"`
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, false, fmt.Errorf("decode response: %w", err)
+	}
+	if out.Embeddings != nil {
+		return out.Embeddings, false, nil
+	}
+	if out.Embedding != nil {
+		return [][]float64{out.Embedding}, false, nil
+	}
+	return nil, false, fmt.Errorf("response has no embedding")
+}
+
+// EmbeddedChunk is a ChunkRecord with its vector, one line of the embed
+// output.
+type EmbeddedChunk struct {
+	ChunkRecord
+	Model     string    `json:"model,omitempty"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// EmbedRecords embeds the text of every record.
+func EmbedRecords(ctx context.Context, embedder Embedder, model string, records []ChunkRecord) ([]EmbeddedChunk, error) {
+	texts := make([]string, len(records))
+	for i, r := range records {
+		texts[i] = r.Text
+	}
+	vectors, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(records) {
+		return nil, fmt.Errorf("got %d embeddings for %d chunks", len(vectors), len(records))
+	}
+	out := make([]EmbeddedChunk, len(records))
+	for i, r := range records {
+		out[i] = EmbeddedChunk{ChunkRecord: r, Model: model, Embedding: vectors[i]}
+	}
+	return out, nil
+}
+
+// RunEmbed parses and chunks a PDF, embeds the chunks and writes them to
+// disk as JSON Lines. It returns the parse result, like RunWithOptions.
+func RunEmbed(ctx context.Context, inputPath, outputPath string, opts Options, chunkOpts ChunkOptions, embedder OllamaEmbedder) (Result, error) {
+	if inputPath == "" || outputPath == "" {
+		return Result{}, fmt.Errorf("both input and output paths are required")
+	}
+
+	result, err := ParseFileWithOptions(inputPath, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	docHash, err := FileSHA256(inputPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("hash input: %w", err)
+	}
+	records := ChunkRecords(inputPath, docHash, result.Chunks(chunkOpts))
+	embedded, err := EmbedRecords(ctx, embedder, embedder.Model, records)
+	if err != nil {
+		return Result{}, fmt.Errorf("embed: %w", err)
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("write failed: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	for _, c := range embedded {
+		if err := enc.Encode(c); err != nil {
+			f.Close()
+			return Result{}, fmt.Errorf("write failed: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return Result{}, fmt.Errorf("write failed: %w", err)
+	}
+	return result, nil
+}
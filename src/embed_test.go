@@ -0,0 +1,87 @@
+package yapp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOllama serves /api/embeddings, failing the first request with 503
+// and recording the peak number of concurrent requests.
+type fakeOllama struct {
+	calls, inFlight, peak atomic.Int32
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for p := f.peak.Load(); n > p && !f.peak.CompareAndSwap(p, n); p = f.peak.Load() {
+	}
+	if f.calls.Add(1) == 1 {
+		http.Error(w, "loading model", http.StatusServiceUnavailable)
+		return
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var req struct {
+		Model  string   `json:"model"`
+		Prompt string   `json:"prompt"`
+		Input  []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "test" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Input != nil {
+		out := make([][]float64, len(req.Input))
+		for i, s := range req.Input {
+			out[i] = []float64{float64(len(s))}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"embeddings": out})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"embedding": []float64{float64(len(req.Prompt))}})
+}
+
+func TestOllamaEmbedder(t *testing.T) {
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff", "ggggggg"}
+
+	for _, batch := range []int{1, 3} {
+		fake := &fakeOllama{}
+		srv := httptest.NewServer(fake)
+		e := OllamaEmbedder{URL: srv.URL, Model: "test", BatchSize: batch, Concurrency: 2, Backoff: time.Millisecond}
+		vectors, err := e.Embed(context.Background(), texts)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("batch %d: %v", batch, err)
+		}
+		for i, v := range vectors {
+			if len(v) != 1 || int(v[0]) != len(texts[i]) {
+				t.Errorf("batch %d: vector %d = %v, want [%d]", batch, i, v, len(texts[i]))
+			}
+		}
+		if peak := fake.peak.Load(); peak > 2 {
+			t.Errorf("batch %d: %d concurrent requests, limit is 2", batch, peak)
+		}
+		wantCalls := (len(texts)+batch-1)/batch + 1 // one retried 503
+		if got := int(fake.calls.Load()); got != wantCalls {
+			t.Errorf("batch %d: %d requests, want %d", batch, got, wantCalls)
+		}
+	}
+}
+
+func TestOllamaEmbedderGivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such model", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	e := OllamaEmbedder{URL: srv.URL, Model: "missing", Backoff: time.Millisecond}
+	if _, err := e.Embed(context.Background(), []string{"x"}); err == nil {
+		t.Fatal("expected an error for a 404")
+	}
+}
@@ -0,0 +1,74 @@
+package yapp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotPDF is returned when the input does not look like a PDF file.
+	ErrNotPDF = errors.New("not a pdf")
+	// ErrCorrupt is returned for PDFs whose structure or content streams
+	// cannot be decoded.
+	ErrCorrupt = errors.New("corrupt pdf")
+	// ErrEncrypted is returned for encrypted PDFs that could not be opened
+	// or decrypted. ErrPasswordRequired and ErrInvalidPassword both match it.
+	ErrEncrypted = errors.New("encrypted pdf")
+	// ErrPasswordRequired is returned for an encrypted PDF that needs a user
+	// password when none was given in Options.Password.
+	ErrPasswordRequired = fmt.Errorf("%w: password required", ErrEncrypted)
+	// ErrInvalidPassword is returned when Options.Password does not open
+	// the encrypted PDF.
+	ErrInvalidPassword = fmt.Errorf("%w: invalid password", ErrEncrypted)
+)
+
+// PageError reports a page that could not be decoded. Without
+// Options.Lenient it aborts parsing; with it, the page is skipped and the
+// error is collected on Result.PageErrors.
+type PageError struct {
+	Page int
+	Err  error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("page %d: %v", e.Page, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// classifyOpenError maps an error from pdf.NewReaderEncrypted onto the
+// exported sentinels, keeping the original message.
+func classifyOpenError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "not a PDF file"):
+		return fmt.Errorf("%w: %w", ErrNotPDF, err)
+	case strings.Contains(msg, "encryption"):
+		return fmt.Errorf("%w: %w", ErrEncrypted, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+}
+
+// recoverCorrupt turns a panic from the pdf package into an error stored
+// in *err: ErrEncrypted when a string or stream could not be decrypted,
+// ErrCorrupt otherwise. Use it as a deferred call.
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		if decryptionPanic(r) {
+			*err = fmt.Errorf("%w: %v", ErrEncrypted, r)
+			return
+		}
+		*err = fmt.Errorf("%w: %v", ErrCorrupt, r)
+	}
+}
+
+// decryptionPanic reports whether a panic from the pdf package came from
+// decrypting a string or stream, going by the messages it panics with.
+func decryptionPanic(r any) bool {
+	msg := fmt.Sprint(r)
+	return strings.HasPrefix(msg, "AES: ") || strings.HasPrefix(msg, "Encrypted text shorter")
+}
@@ -0,0 +1,77 @@
+package yapp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ChunkRecord is one line of the jsonl-chunks output.
+type ChunkRecord struct {
+	ID          string   `json:"id"`
+	Text        string   `json:"text"`
+	HeadingPath []string `json:"heading_path"`
+	Pages       []int    `json:"pages"`
+	BBoxes      []BBox   `json:"bboxes"`
+	Source      string   `json:"source"`
+	SHA256      string   `json:"sha256"` // of Text
+}
+
+// ChunkRecords prepares chunks for export. docHash identifies the source
+// file's content (see FileSHA256); IDs combine it with the chunk index, so
+// rerunning the same file with the same options yields the same IDs.
+func ChunkRecords(source, docHash string, chunks []Chunk) []ChunkRecord {
+	prefix := docHash
+	if len(prefix) > 16 {
+		prefix = prefix[:16]
+	}
+	records := make([]ChunkRecord, len(chunks))
+	for i, c := range chunks {
+		sum := sha256.Sum256([]byte(c.Text))
+		path := c.HeadingPath
+		if path == nil {
+			path = []string{}
+		}
+		records[i] = ChunkRecord{
+			ID:          fmt.Sprintf("%s-%04d", prefix, c.Index),
+			Text:        c.Text,
+			HeadingPath: path,
+			Pages:       c.Pages,
+			BBoxes:      c.BBoxes,
+			Source:      source,
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+	}
+	return records
+}
+
+// WriteChunksJSONL writes one JSON object per line.
+func WriteChunksJSONL(w io.Writer, records []ChunkRecord) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("encode chunk %s: %w", r.ID, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// FileSHA256 returns the hex SHA-256 of a file's content.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,223 @@
+package yapp
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// garbledShare is the share of undecodable characters above which a
+	// font or a page counts as garbled.
+	garbledShare = 0.2
+	// minVowelShare and maxVowelShare bound the share of vowels among the
+	// lowercase Latin letters of text in a natural language, and
+	// vowelSample is how many letters it takes to judge it.
+	minVowelShare = 0.2
+	maxVowelShare = 0.65
+	vowelSample   = 50
+)
+
+// FontReport describes how well the text of one font could be decoded.
+// Subsets of a font are reported apart.
+type FontReport struct {
+	// Name is the font's BaseFont, with the subset prefix if it has one.
+	Name    string `json:"name"`
+	Subtype string `json:"subtype,omitempty"` // Type1, TrueType, Type0, Type3
+	// Encoding is the font's /Encoding name, "custom" for a Differences
+	// array, or empty when there is none.
+	Encoding string `json:"encoding,omitempty"`
+	// ToUnicode is set when the font maps its codes to Unicode. Fonts
+	// without it and without a standard encoding decode to guesswork.
+	ToUnicode bool  `json:"toUnicode"`
+	Pages     []int `json:"pages"`
+	Chars     int   `json:"chars"`
+	// Replacement counts U+FFFD characters, codes the font could not map,
+	// which are dropped from the text.
+	Replacement int `json:"replacement,omitempty"`
+	// Suspicious counts control and unmapped private-use characters, which
+	// codes a custom encoding decoded as a standard one often become.
+	Suspicious int `json:"suspicious,omitempty"`
+	// Implausible is set when the font's lowercase Latin letters have far
+	// fewer or far more vowels than any language written in them, as when
+	// a custom encoding decodes to ordinary but wrong letters.
+	Implausible bool `json:"implausible,omitempty"`
+	// Garbled is set when replacement and suspicious characters make up
+	// more than a fifth of the font's text, or its letters are implausible.
+	Garbled bool `json:"garbled,omitempty"`
+}
+
+// ReplacementShare is the share of the font's characters that could not be
+// decoded.
+func (f FontReport) ReplacementShare() float64 {
+	if f.Chars == 0 {
+		return 0
+	}
+	return float64(f.Replacement) / float64(f.Chars)
+}
+
+// fontInfo is what a font dictionary says about the font's encoding.
+type fontInfo struct {
+	name, subtype, encoding string
+	toUnicode               bool
+}
+
+func describeFont(font pdf.Value, name string) fontInfo {
+	info := fontInfo{
+		name:      name,
+		subtype:   font.Key("Subtype").Name(),
+		toUnicode: font.Key("ToUnicode").Kind() == pdf.Stream,
+	}
+	switch enc := font.Key("Encoding"); enc.Kind() {
+	case pdf.Name:
+		info.encoding = enc.Name()
+	case pdf.Dict:
+		info.encoding = "custom"
+	}
+	return info
+}
+
+// undecodable reports whether r shows that a font's text was not decoded:
+// U+FFFD, a control character, or a private-use character table has no
+// replacement for.
+func undecodable(r rune, table map[rune]string) bool {
+	switch {
+	case r == '\uFFFD':
+		return true
+	case r == '\t' || r == '\n' || r == '\r':
+		return false
+	case unicode.IsControl(r):
+		return true
+	case unicode.Is(unicode.Co, r):
+		_, ok := table[r]
+		return !ok
+	}
+	return false
+}
+
+// fontCensus collects FontReports across the pages of a document.
+type fontCensus struct {
+	reports []FontReport
+	index   map[string]int
+	letters []letterCount // per report
+	// pages holds each page's character counts by report.
+	pages map[int]map[int]charCount
+}
+
+// charCount tallies a font's characters on a page and how many of them
+// could not be decoded.
+type charCount struct {
+	chars, bad int
+}
+
+// letterCount tallies lowercase Latin letters and the vowels among them.
+type letterCount struct {
+	letters, vowels int
+}
+
+// implausible reports whether the letters are enough to judge and their
+// vowel share is out of the range of natural language.
+func (c letterCount) implausible() bool {
+	if c.letters < vowelSample {
+		return false
+	}
+	share := float64(c.vowels) / float64(c.letters)
+	return share < minVowelShare || share > maxVowelShare
+}
+
+// isVowel reports whether r is a lowercase Latin vowel, accented or not.
+func isVowel(r rune) bool {
+	return strings.ContainsRune("aeiouyàáâãäåæèéêëìíîïòóôõöøùúûüýÿ", r)
+}
+
+// addPage tallies the characters of a page's glyphs by font. Whether the
+// fonts and the page are garbled is judged once all pages are added.
+func (c *fontCensus) addPage(page int, fonts []fontInfo, glyphs []glyph, table map[rune]string) {
+	if c.index == nil {
+		c.index = make(map[string]int)
+		c.pages = make(map[int]map[int]charCount)
+	}
+	report := func(name string) int {
+		i, ok := c.index[name]
+		if !ok {
+			i = len(c.reports)
+			c.index[name] = i
+			c.reports = append(c.reports, FontReport{Name: name})
+			c.letters = append(c.letters, letterCount{})
+		}
+		f := &c.reports[i]
+		if n := len(f.Pages); n == 0 || f.Pages[n-1] != page {
+			f.Pages = append(f.Pages, page)
+		}
+		return i
+	}
+	for _, info := range fonts {
+		f := &c.reports[report(info.name)]
+		f.Subtype, f.Encoding = info.subtype, info.encoding
+		f.ToUnicode = f.ToUnicode || info.toUnicode
+	}
+
+	counts := make(map[int]charCount)
+	for _, g := range glyphs {
+		name := g.baseFont
+		if name == "" {
+			name = g.Font
+		}
+		i := report(name)
+		f, letters, n := &c.reports[i], &c.letters[i], counts[i]
+		for _, r := range g.S {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			f.Chars++
+			n.chars++
+			switch {
+			case r == '\uFFFD':
+				f.Replacement++
+				n.bad++
+			case undecodable(r, table):
+				f.Suspicious++
+				n.bad++
+			case r <= unicode.MaxLatin1 && unicode.IsLower(r):
+				letters.letters++
+				if isVowel(r) {
+					letters.vowels++
+				}
+			}
+		}
+		counts[i] = n
+	}
+	c.pages[page] = counts
+
+	for i := range c.reports {
+		f := &c.reports[i]
+		f.Implausible = c.letters[i].implausible()
+		f.Garbled = f.Chars > 0 && float64(f.Replacement+f.Suspicious) > float64(f.Chars)*garbledShare || f.Implausible
+	}
+}
+
+// garbled reports whether a page added to the census is garbled: whether
+// over a fifth of its characters could not be decoded or are in fonts
+// whose letters are implausible. It is only final once every page is
+// added.
+func (c *fontCensus) garbled(page int) bool {
+	chars, bad := 0, 0
+	for i, n := range c.pages[page] {
+		chars += n.chars
+		if c.reports[i].Implausible {
+			bad += n.chars
+		} else {
+			bad += n.bad
+		}
+	}
+	return chars > 0 && float64(bad) > float64(chars)*garbledShare
+}
+
+// fonts returns the reports ordered by name.
+func (c *fontCensus) fonts() []FontReport {
+	out := append([]FontReport(nil), c.reports...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,88 @@
+package yapp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func TestGarbledPages(t *testing.T) {
+	path := writeTestPDF(t,
+		"BT /F1 12 Tf 72 700 Td (Readable text) Tj ET",
+		"BT /F2 12 Tf 72 700 Td (\\001\\002\\003\\004 \\005\\006\\007) Tj ET")
+
+	res, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if res.Pages[0].Garbled || !res.Pages[1].Garbled {
+		t.Fatalf("garbled = %v, %v; want page 2 only", res.Pages[0].Garbled, res.Pages[1].Garbled)
+	}
+	var courier FontReport
+	for _, f := range res.Fonts {
+		if f.Name == "Courier" {
+			courier = f
+		}
+	}
+	if !courier.Garbled || courier.ToUnicode || courier.Chars != 7 || courier.Suspicious != 7 || len(courier.Pages) != 1 || courier.Pages[0] != 2 {
+		t.Fatalf("Courier report = %+v, want 7 suspicious characters on page 2", courier)
+	}
+	if got := fontSummary(res.Fonts); !strings.Contains(got, "font Courier garbled (no ToUnicode map): 7 of 7") {
+		t.Errorf("font summary = %q", got)
+	}
+
+	engine := OCRFunc(func(page OCRPage) ([]OCRWord, error) {
+		return []OCRWord{{Text: "Recovered", X: 72, Y: 700, Width: 60, Height: 12, Confidence: 90}}, nil
+	})
+	res, err = ParseFileWithOptions(path, Options{OCR: engine, OCRGarbled: true})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !res.Pages[1].OCR || !strings.Contains(res.Markdown, "Recovered") || res.Pages[0].OCR {
+		t.Fatalf("garbled page not sent to OCR: %+v\n%s", res.Pages, res.Markdown)
+	}
+}
+
+func TestFontCensusSubsetsAndLetters(t *testing.T) {
+	text := func(font, base, s string) glyph {
+		return glyph{Text: pdf.Text{Font: font, FontSize: 10, S: s}, baseFont: base}
+	}
+	var census fontCensus
+	fonts := []fontInfo{
+		{name: "ABCDEF+Minion", toUnicode: true},
+		{name: "GHIJKL+Minion"},
+		{name: "Helvetica"},
+	}
+	census.addPage(1, fonts, []glyph{
+		text("Minion", "ABCDEF+Minion", "the quick brown fox jumps over the lazy dog and then some more words"),
+		text("Minion", "GHIJKL+Minion", strings.Repeat("xqzt bkdr ", 8)),
+		text("Helvetica", "Helvetica", "Short"),
+	}, glyphReplacements)
+	reports := census.fonts()
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want one per subset: %+v", len(reports), reports)
+	}
+	for _, f := range reports {
+		want := f.Name == "GHIJKL+Minion"
+		if f.Implausible != want || f.Garbled != want {
+			t.Errorf("%s: implausible %v, garbled %v; want %v", f.Name, f.Implausible, f.Garbled, want)
+		}
+		if f.Name == "GHIJKL+Minion" && f.ToUnicode {
+			t.Errorf("%s borrowed its sibling's ToUnicode", f.Name)
+		}
+	}
+	if !census.garbled(1) {
+		t.Error("page with implausible letters not garbled")
+	}
+
+	// A font is judged on all of its pages, however little of it the first
+	// one has.
+	census = fontCensus{}
+	for page := 1; page <= 2; page++ {
+		census.addPage(page, nil, []glyph{text("Minion", "GHIJKL+Minion", strings.Repeat("xqzt bkdr ", 4))}, glyphReplacements)
+	}
+	if !census.garbled(1) || !census.garbled(2) {
+		t.Errorf("garbled = %v, %v; want both pages", census.garbled(1), census.garbled(2))
+	}
+}
@@ -0,0 +1,216 @@
+package yapp
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// footnoteSizeRatio is the largest size, relative to the body text, a line
+// can have and still be part of a footnote.
+const footnoteSizeRatio = 0.92
+
+// footnoteSymbols are the non-numeric markers footnotes are commonly
+// numbered with.
+const footnoteSymbols = "*†‡§¶"
+
+// scriptFootnoteRef marks a superscript splitFootnotes took for a
+// footnote's reference, so that it is not rendered as one. The text of
+// every reference holds the marker between refStart and refEnd, for
+// linkFootnotes to turn into the reference.
+const (
+	scriptFootnoteRef Script = "footnote"
+	refStart                 = "\uFFF9"
+	refEnd                   = "\uFFFB"
+)
+
+// splitFootnotes takes the footnotes off the bottom of a page: the run of
+// small-font lines at the end of the page, starting at the first of them
+// that opens with a marker the text above refers to. Lines without such a
+// marker continue the footnote before them, so a small line that merely
+// starts with a number, like a page footer, is not a footnote.
+func splitFootnotes(lines []lineStyle, bodySize float64) (body []lineStyle, notes []element) {
+	start := len(lines)
+	for start > 0 && lines[start-1].fontSize < bodySize*footnoteSizeRatio {
+		start--
+	}
+	refs := footnoteRefs(lines[:start])
+	for start < len(lines) {
+		if marker, _, ok := footnoteMarker(lines[start]); ok && refs[marker] {
+			break
+		}
+		start++
+	}
+	if start == len(lines) {
+		return lines, nil
+	}
+
+	for _, line := range lines[start:] {
+		if marker, text, ok := footnoteMarker(line); ok && refs[marker] {
+			notes = append(notes, element{kind: elemFootnote, label: marker, parts: []string{text}, lines: []lineStyle{line}})
+			continue
+		}
+		n := &notes[len(notes)-1]
+		n.parts = append(n.parts, strings.TrimSpace(line.text))
+		n.lines = append(n.lines, line)
+	}
+	return markFootnoteRefs(lines[:start], notes), notes
+}
+
+// footnoteRefs collects the possible footnote references in lines: the
+// text of superscripts and of spans set smaller than their line.
+func footnoteRefs(lines []lineStyle) map[string]bool {
+	refs := make(map[string]bool)
+	for _, line := range lines {
+		for _, span := range line.spans {
+			if marker, _, ok := refMarker(line, span); ok {
+				refs[marker] = true
+			}
+		}
+	}
+	return refs
+}
+
+// refMarker returns the marker a span would refer to a footnote with, and
+// what follows it, if the span is a superscript or smaller than its line.
+func refMarker(line lineStyle, span TextSpan) (marker, rest string, ok bool) {
+	if span.Pos.Script != ScriptSuper && span.Pos.FontSize >= line.fontSize*footnoteSizeRatio {
+		return "", "", false
+	}
+	text := strings.TrimSpace(span.Text)
+	marker = strings.TrimRight(text, ".,;:)")
+	return marker, text[len(marker):], marker != ""
+}
+
+// markFootnoteRefs marks the span each note is referred to by, in the
+// order of the notes: the first span with its marker after the previous
+// note's reference, or failing that the first anywhere, preferring spans
+// that do not follow what reads as the base of an exponent. Other spans
+// with the same text, such as exponents, are left alone. Lines with a
+// reference are copied, not changed in place.
+func markFootnoteRefs(lines []lineStyle, notes []element) []lineStyle {
+	out := append([]lineStyle(nil), lines...)
+	type at struct{ line, span int }
+	marked := make(map[at]bool)
+	find := func(marker string, from at, afterWord bool) (at, bool) {
+		for i := from.line; i < len(out); i++ {
+			for j, span := range out[i].spans {
+				if i == from.line && j < from.span || marked[at{i, j}] {
+					continue
+				}
+				if afterWord && j > 0 && exponentBase(out[i].spans[j-1].Text) {
+					continue
+				}
+				if m, _, ok := refMarker(out[i], span); ok && m == marker {
+					return at{i, j}, true
+				}
+			}
+		}
+		return at{}, false
+	}
+	var last at
+	for _, note := range notes {
+		var ref at
+		ok := false
+		for _, afterWord := range []bool{true, false} {
+			if ref, ok = find(note.label, last, afterWord); ok {
+				break
+			}
+			if ref, ok = find(note.label, at{}, afterWord); ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		marked[ref] = true
+		last = at{ref.line, ref.span + 1}
+
+		line := out[ref.line]
+		spans := append([]TextSpan(nil), line.spans...)
+		marker, rest, _ := refMarker(line, spans[ref.span])
+		spans[ref.span].Text = refStart + marker + refEnd + rest
+		if spans[ref.span].Pos.Script == ScriptSuper {
+			spans[ref.span].Pos.Script = scriptFootnoteRef
+		}
+		spans[ref.span].Pos.Monospace = false
+		out[ref.line] = newLineStyle(spans)
+	}
+	return out
+}
+
+// footnoteMarker splits a footnote line into its marker and text. The
+// marker is either a leading superscript or a number or symbol followed by
+// a space, an optional period or parenthesis in between.
+func footnoteMarker(line lineStyle) (marker, text string, ok bool) {
+	s := strings.TrimSpace(line.text)
+	if len(line.spans) > 0 && line.spans[0].Pos.Script == ScriptSuper {
+		marker = strings.TrimSpace(line.spans[0].Text)
+		s = strings.TrimPrefix(s, scriptMarkup(marker, ScriptSuper))
+	} else {
+		end := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsDigit(r) && !strings.ContainsRune(footnoteSymbols, r)
+		})
+		if end <= 0 {
+			return "", "", false
+		}
+		marker = s[:end]
+		if n, err := strconv.Atoi(marker); err == nil && (n == 0 || n > 999) {
+			return "", "", false
+		}
+		s = strings.TrimPrefix(strings.TrimPrefix(s[end:], "."), ")")
+		if !strings.HasPrefix(s, " ") {
+			return "", "", false
+		}
+	}
+	text = strings.TrimSpace(s)
+	if marker == "" || strings.ContainsAny(marker, " ") || text == "" {
+		return "", "", false
+	}
+	return marker, text, true
+}
+
+// exponentBase reports whether text ends in what an exponent is raised on
+// rather than a word: a single letter, a digit or a closing bracket.
+func exponentBase(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	last := []rune(fields[len(fields)-1])
+	r := last[len(last)-1]
+	return len(last) == 1 && unicode.IsLetter(r) || unicode.IsDigit(r) || r == ')' || r == ']'
+}
+
+// linkFootnotes gives every note a label unique in the document and turns
+// the references markFootnoteRefs marked in the page's elements into
+// references to it.
+func linkFootnotes(elements, notes []element, page int, used map[string]bool) {
+	for i := range notes {
+		marker := notes[i].label
+		label := marker
+		if used[label] {
+			label = strconv.Itoa(page) + "-" + marker
+		}
+		used[label] = true
+		notes[i].label = label
+
+		mark, ref := refStart+marker+refEnd, "[^"+label+"]"
+		linked := false
+		link := func(s *string) {
+			if !linked && strings.Contains(*s, mark) {
+				*s, linked = strings.Replace(*s, mark, ref, 1), true
+			}
+		}
+		for j := range elements {
+			for k := range elements[j].parts {
+				link(&elements[j].parts[k])
+			}
+			for _, row := range elements[j].rows {
+				for k := range row {
+					link(&row[k])
+				}
+			}
+		}
+	}
+}
@@ -1,7 +1,9 @@
 module github.com/bentor/yapp
 
-go 1.25
+go 1.25.0
 
 require github.com/ledongthuc/pdf v0.0.0-20250511090121-5959a4027728
 
+require golang.org/x/text v0.40.0
+
 replace github.com/ledongthuc/pdf => ../../pdf
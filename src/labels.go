@@ -0,0 +1,114 @@
+package yapp
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// labelRange is an entry of a document's /PageLabels: the pages from
+// start (counted from 0) up to the next range are labelled prefix followed
+// by a number in style, counting up from first.
+type labelRange struct {
+	start  int
+	style  string // D, R, r, A or a; empty for the prefix alone
+	prefix string
+	first  int
+}
+
+// pageLabels are a document's page label ranges, sorted by start.
+type pageLabels []labelRange
+
+// readPageLabels reads the number tree in the catalog's /PageLabels. A
+// tree the pdf package cannot read gives no labels.
+func readPageLabels(reader *pdf.Reader) (labels pageLabels) {
+	defer func() {
+		if recover() != nil {
+			labels = nil
+		}
+	}()
+
+	var walk func(node pdf.Value, depth int)
+	walk = func(node pdf.Value, depth int) {
+		if node.Kind() != pdf.Dict || depth > maxStructDepth {
+			return
+		}
+		nums := node.Key("Nums")
+		for i := 0; i+1 < nums.Len(); i += 2 {
+			key, v := nums.Index(i), nums.Index(i+1)
+			if key.Kind() != pdf.Integer || v.Kind() != pdf.Dict {
+				continue
+			}
+			r := labelRange{
+				start:  int(key.Int64()),
+				style:  v.Key("S").Name(),
+				prefix: v.Key("P").Text(),
+				first:  1,
+			}
+			if st := v.Key("St"); st.Kind() == pdf.Integer && st.Int64() > 0 {
+				r.first = int(st.Int64())
+			}
+			labels = append(labels, r)
+		}
+		kids := node.Key("Kids")
+		for i := 0; i < kids.Len(); i++ {
+			walk(kids.Index(i), depth+1)
+		}
+	}
+	walk(reader.Trailer().Key("Root").Key("PageLabels"), 0)
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].start < labels[j].start })
+	return labels
+}
+
+// label returns the label of page n, numbered from 1, or "" when no range
+// covers it.
+func (p pageLabels) label(n int) string {
+	i := sort.Search(len(p), func(i int) bool { return p[i].start > n-1 }) - 1
+	if i < 0 {
+		return ""
+	}
+	r := p[i]
+	value := r.first + n - 1 - r.start
+	switch r.style {
+	case "D":
+		return r.prefix + strconv.Itoa(value)
+	case "R":
+		return r.prefix + strings.ToUpper(roman(value))
+	case "r":
+		return r.prefix + roman(value)
+	case "A":
+		return r.prefix + strings.ToUpper(letters(value))
+	case "a":
+		return r.prefix + letters(value)
+	}
+	return r.prefix
+}
+
+// roman writes n in lowercase Roman numerals.
+func roman(n int) string {
+	numerals := []struct {
+		value  int
+		digits string
+	}{
+		{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"}, {100, "c"}, {90, "xc"},
+		{50, "l"}, {40, "xl"}, {10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+	}
+	var b strings.Builder
+	for _, r := range numerals {
+		for ; n >= r.value; n -= r.value {
+			b.WriteString(r.digits)
+		}
+	}
+	return b.String()
+}
+
+// letters writes n the way page labels count in letters: a to z, then aa
+// to zz, then aaa and so on.
+func letters(n int) string {
+	if n < 1 {
+		return ""
+	}
+	return strings.Repeat(string(rune('a'+(n-1)%26)), (n-1)/26+1)
+}
@@ -1,8 +1,10 @@
 package yapp
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"os"
 	"sort"
 	"strings"
 	"unicode"
@@ -21,84 +23,454 @@ const (
 
 // Lexer walks the PDF and emits tokens akin to lex/flex.
 type Lexer struct {
-	path string
+	path       string
+	opts       Options
+	reports    []PageReport
+	pageErrors []*PageError
+	fonts      fontCensus
+	structure  structTree
+	geometry   map[int]PageGeometry
 }
 
 func NewLexer(path string) *Lexer {
 	return &Lexer{path: path}
 }
 
-func (l *Lexer) Tokenize() ([]Token, error) {
-	file, reader, err := pdf.Open(l.path)
+// NewLexerWithOptions is NewLexer with explicit lexing options.
+func NewLexerWithOptions(path string, opts Options) *Lexer {
+	return &Lexer{path: path, opts: opts}
+}
+
+func (l *Lexer) Tokenize() (tokens []Token, err error) {
+	file, reader, err := openPDF(l.path, l.opts.Password)
 	if err != nil {
 		return nil, fmt.Errorf("open pdf: %w", err)
 	}
 	defer file.Close()
+	// The pdf package panics on malformed objects. Each step of a page's
+	// processing is guarded and fails just that page; anything else is
+	// reported as a corrupt document.
+	defer recoverCorrupt(&err)
 
-	tokens := make([]Token, 0)
+	tokens = make([]Token, 0)
 	totalPages := reader.NumPage()
+	l.reports = make([]PageReport, 0, totalPages)
+	l.pageErrors = nil
+	l.fonts = fontCensus{}
+	l.structure = readStructTree(reader, l.opts.Pages)
+	l.geometry = make(map[int]PageGeometry)
+	labels := readPageLabels(reader)
+	normalize := newNormalizer(l.opts.Normalization, l.opts.GlyphMap)
+
+	// Watermarks are told apart partly by repeating on every page, so all
+	// pages are read before any is cleaned of them. Pages left out by
+	// Options.Pages are not decoded at all.
+	pages := make([]pageRead, totalPages)
+	selected := 0
+	for i := range pages {
+		p := &pages[i]
+		if p.skipped = !l.opts.Pages.Contains(i + 1); p.skipped {
+			continue
+		}
+		selected++
+		if p.err = l.readPass(reader, p, i+1, labels); p.err != nil {
+			if err := l.pageFailed(i+1, p.err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if selected == 0 && l.opts.Pages != nil {
+		return nil, fmt.Errorf("pages %s: document has %d pages", l.opts.Pages, totalPages)
+	}
+	repeated := repeatedStamps(pages)
+
+	// Whether a font's letters are plausible is judged on all of its text,
+	// so every page is counted before any is judged garbled.
+	for i := range pages {
+		p := &pages[i]
+		if p.skipped || p.err != nil {
+			continue
+		}
+		if p.glyphs, p.watermarks, p.err = l.cleanPage(p.glyphs, repeated, l.geometry[i+1].CropBox); p.err != nil {
+			if err := l.pageFailed(i+1, p.err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		l.fonts.addPage(i+1, p.content.fonts, p.glyphs, normalize.table)
+	}
 
 	for pageIndex := 1; pageIndex <= totalPages; pageIndex++ {
-		if pageIndex > 1 {
+		if pages[pageIndex-1].skipped {
+			continue
+		}
+		if len(l.reports) > 0 {
 			tokens = append(tokens, Token{
 				Type: TokenPageBreak,
 				Pos:  Position{Page: pageIndex},
 			})
 		}
 
-		page := reader.Page(pageIndex)
-		if page.V.IsNull() || page.V.Key("Contents").Kind() == pdf.Null {
+		report := PageReport{Number: pageIndex}
+		page, content, glyphs := pages[pageIndex-1].page, pages[pageIndex-1].content, pages[pageIndex-1].glyphs
+		if err := pages[pageIndex-1].err; err != nil {
+			l.reports = append(l.reports, failedReport(pageIndex, err))
 			continue
 		}
+		report.Images = len(content.images)
+		report.OffPage = pages[pageIndex-1].offPage
+		report.Watermarks = pages[pageIndex-1].watermarks
+		report.Garbled = l.fonts.garbled(pageIndex)
+		if (!hasText(glyphs) || report.Garbled && l.opts.OCRGarbled) && l.opts.OCR != nil {
+			recognized, err := ocrGlyphs(l.opts.OCR, OCRPage{Path: l.path, Password: l.opts.Password, Number: pageIndex}, page, content.images, pages[pageIndex-1].boxes)
+			if err != nil {
+				err = fmt.Errorf("ocr: %w", err)
+				if abort := l.pageFailed(pageIndex, err); abort != nil {
+					return nil, abort
+				}
+				report.Error = err.Error()
+			} else {
+				glyphs = recognized
+				report.OCR = true
+			}
+		}
 
-		glyphs := page.Content().Text
-		if len(glyphs) == 0 {
+		pageTokens, replaced, err := tokenizeGlyphs(glyphs, pageIndex, normalize)
+		if err != nil {
+			if abort := l.pageFailed(pageIndex, err); abort != nil {
+				return nil, abort
+			}
+			l.reports = append(l.reports, failedReport(pageIndex, err))
 			continue
 		}
+		report.Replacements = replaced
+		for _, tok := range pageTokens {
+			if tok.Type == TokenWord {
+				report.Words++
+			}
+		}
+		tokens = append(tokens, pageTokens...)
+
+		switch {
+		case report.Words > 0 && report.OCR:
+			report.Status = PageOCR
+		case report.Words > 0:
+			report.Status = PageText
+		case report.Images > 0:
+			report.Status = PageImageOnly
+		default:
+			report.Status = PageEmpty
+		}
+		l.reports = append(l.reports, report)
+	}
+
+	tokens = append(tokens, Token{
+		Type: TokenEOF,
+		Pos:  Position{Page: totalPages},
+	})
+
+	return tokens, nil
+}
+
+// readPass reads page number into p for the first pass of Tokenize: its
+// glyphs, visible and in reading orientation, and its boxes.
+func (l *Lexer) readPass(reader *pdf.Reader, p *pageRead, number int, labels pageLabels) (err error) {
+	defer recoverCorrupt(&err)
+
+	if p.page, p.content, err = readPage(reader, number); err != nil {
+		return err
+	}
+	p.boxes = readPageBoxes(p.page)
+	l.geometry[number] = p.boxes.geometry(labels.label(number))
+	p.offPage = markOffPage(p.content.glyphs, p.boxes.crop)
+	rotatePage(p.content.glyphs, p.boxes.rotation, p.boxes.media)
+	for j := range p.content.glyphs {
+		p.content.glyphs[j].element = l.structure.element(number, p.content.glyphs[j])
+	}
+	p.glyphs = collapseOverdraw(l.filterInvisible(p.content.glyphs))
+	if !l.opts.KeepArtifacts {
+		p.glyphs = dropArtifacts(p.glyphs)
+	}
+	if !l.opts.KeepOffPage {
+		p.glyphs = dropOffPage(p.glyphs)
+	}
+	return nil
+}
+
+// cleanPage flags a page's watermarks and running footer and drops them
+// as the options say. It returns the glyphs left and how many runs of
+// watermark text it found.
+func (l *Lexer) cleanPage(glyphs []glyph, repeated map[string]bool, crop Box) (kept []glyph, watermarks int, err error) {
+	defer recoverCorrupt(&err)
+
+	watermarks = markWatermarks(glyphs, repeated)
+	if !l.opts.KeepWatermarks {
+		glyphs = dropWatermarks(glyphs)
+	}
+	markFooters(glyphs, repeated, crop)
+	if !l.opts.KeepArtifacts {
+		glyphs = dropArtifacts(glyphs)
+	}
+	return glyphs, watermarks, nil
+}
+
+// tokenizeGlyphs tokenizes and normalizes a page's glyphs, returning how
+// many replacements normalization made.
+func tokenizeGlyphs(glyphs []glyph, pageIndex int, normalize normalizer) (tokens []Token, replaced int, err error) {
+	defer recoverCorrupt(&err)
+
+	tokens, replaced = normalizeTokens(tokenizePage(glyphs, pageIndex), normalize)
+	return tokens, replaced, nil
+}
+
+// failedReport is the report of a page that could not be decoded.
+func failedReport(number int, err error) PageReport {
+	status := PageDecodeError
+	if errors.Is(err, ErrEncrypted) {
+		status = PageEncrypted
+	}
+	return PageReport{Number: number, Status: status, Error: err.Error()}
+}
 
-		sort.Sort(pdf.TextVertical(glyphs))
-		lines := groupLines(glyphs)
+// pageRead is a page as read by the first pass of Tokenize.
+type pageRead struct {
+	page    pdf.Page
+	content pageContent
+	glyphs  []glyph // visible glyphs in reading orientation
+	err     error
+	offPage int // glyphs outside the CropBox
+	boxes   pageBoxes
+	skipped bool // not in Options.Pages
+	// watermarks counts the runs of watermark text cleanPage found.
+	watermarks int
+}
 
-		var prevY, prevHeight float64
-		var havePrev bool
+// openPDF opens a PDF for reading. Encrypted files (RC4 or AES-128, the
+// standard security handler) are decrypted with password.
+func openPDF(path, password string) (*os.File, *pdf.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
 
-		for _, line := range lines {
-			if len(line) == 0 {
-				continue
+	var pw func() string
+	if password != "" {
+		tried := false
+		pw = func() string {
+			if tried {
+				return ""
 			}
-			lineY := line[0].Y
-			lineHeight := maxFontSize(line)
+			tried = true
+			return latin1(password)
+		}
+	}
 
-			if havePrev {
-				gap := prevY - lineY
-				if gap > math.Max(prevHeight, lineHeight)*1.35 {
-					tokens = append(tokens, Token{Type: TokenNewline, Pos: Position{Page: pageIndex, Y: lineY}})
-				}
+	reader, err := newReader(f, fi.Size(), pw)
+	if err != nil {
+		f.Close()
+		if errors.Is(err, pdf.ErrInvalidPassword) {
+			if password == "" {
+				return nil, nil, ErrPasswordRequired
 			}
+			return nil, nil, ErrInvalidPassword
+		}
+		if errors.Is(err, ErrCorrupt) {
+			return nil, nil, err
+		}
+		return nil, nil, classifyOpenError(err)
+	}
+	return f, reader, nil
+}
+
+func newReader(f *os.File, size int64, pw func() string) (reader *pdf.Reader, err error) {
+	defer recoverCorrupt(&err)
+	return pdf.NewReaderEncrypted(f, size, pw)
+}
+
+// latin1 re-encodes a password the way PDF 1.7 readers expect for RC4 and
+// AES-128 files; passwords with characters outside Latin-1 are left as UTF-8.
+func latin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return s
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// Reports returns one PageReport per page seen by the last Tokenize call.
+func (l *Lexer) Reports() []PageReport {
+	return l.reports
+}
+
+// Fonts returns a report per font the last Tokenize call decoded text
+// from.
+func (l *Lexer) Fonts() []FontReport {
+	return l.fonts.fonts()
+}
+
+// Structure returns the structure tree the last Tokenize call read from a
+// Tagged PDF.
+func (l *Lexer) Structure() []StructElement {
+	return l.structure.elements
+}
+
+// Geometry returns the size, orientation and label of every page the last
+// Tokenize call read, by page number.
+func (l *Lexer) Geometry() map[int]PageGeometry {
+	return l.geometry
+}
+
+// PageErrors returns the pages the last Tokenize call skipped in lenient mode.
+func (l *Lexer) PageErrors() []*PageError {
+	return l.pageErrors
+}
+
+// pageFailed records a page that could not be read. It returns the error
+// that should abort tokenizing, or nil in lenient mode.
+func (l *Lexer) pageFailed(page int, err error) error {
+	pageErr := &PageError{Page: page, Err: err}
+	if !l.opts.Lenient {
+		return pageErr
+	}
+	l.pageErrors = append(l.pageErrors, pageErr)
+	return nil
+}
+
+// tokenizePage groups a page's glyphs into lines and words. Text running
+// in other directions than left to right, such as rotated table headers or
+// vertical writing, is tokenized on its own, after the horizontal text and
+// in a block of its own.
+func tokenizePage(glyphs []glyph, pageIndex int) []Token {
+	var tokens []Token
+	for _, run := range splitDirections(glyphs) {
+		if len(tokens) > 0 {
+			tokens = append(tokens, Token{Type: TokenNewline, Pos: Position{Page: pageIndex}})
+		}
+		toReadingFrame(run.glyphs, run.angle)
+		runTokens := tokenizeLines(run.glyphs, pageIndex)
+		fromReadingFrame(runTokens, run.angle)
+		tokens = append(tokens, runTokens...)
+	}
+	return tokens
+}
+
+// tokenizeLines groups glyphs running left to right into lines and words.
+func tokenizeLines(glyphs []glyph, pageIndex int) []Token {
+	if len(glyphs) == 0 {
+		return nil
+	}
+
+	var tokens []Token
+	sort.Sort(glyphsVertical(glyphs))
+	lines := attachScriptLines(groupLines(glyphs))
+
+	var prevY, prevHeight float64
+	var havePrev bool
+
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		lineY := line[0].Y
+		lineHeight := maxFontSize(line)
+		markScripts(line)
 
-			words := buildWords(line, pageIndex)
-			tokens = append(tokens, words...)
-			if len(words) > 0 {
+		if havePrev {
+			gap := prevY - lineY
+			if gap > math.Max(prevHeight, lineHeight)*1.35 {
 				tokens = append(tokens, Token{Type: TokenNewline, Pos: Position{Page: pageIndex, Y: lineY}})
 			}
+		}
 
-			prevY = lineY
-			prevHeight = lineHeight
-			havePrev = true
+		words := logicalOrder(buildWords(line, pageIndex))
+		tokens = append(tokens, words...)
+		if len(words) > 0 {
+			tokens = append(tokens, Token{Type: TokenNewline, Pos: Position{Page: pageIndex, Y: lineY}})
 		}
+
+		prevY = lineY
+		prevHeight = lineHeight
+		havePrev = true
 	}
+	return tokens
+}
 
-	tokens = append(tokens, Token{
-		Type: TokenEOF,
-		Pos:  Position{Page: totalPages},
-	})
+// filterInvisible applies Options.InvisibleText to a page's glyphs.
+func (l *Lexer) filterInvisible(glyphs []glyph) []glyph {
+	switch l.opts.InvisibleText {
+	case InvisibleInclude:
+		return glyphs
+	case InvisibleAuto:
+		// A page with nothing but invisible text is an OCR layer over a
+		// scanned image; that text is all the page has to offer.
+		visible := false
+		for _, g := range glyphs {
+			if !g.invisible() && strings.TrimSpace(g.S) != "" {
+				visible = true
+				break
+			}
+		}
+		if !visible {
+			return glyphs
+		}
+	}
 
-	return tokens, nil
+	kept := glyphs[:0]
+	for _, g := range glyphs {
+		if !g.invisible() {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// hasText reports whether any glyph carries printable text.
+func hasText(glyphs []glyph) bool {
+	for _, g := range glyphs {
+		if s := strings.TrimSpace(g.S); s != "" && s != "\uFFFD" {
+			return true
+		}
+	}
+	return false
+}
+
+// glyphsVertical orders glyphs top to bottom, then left to right, matching
+// pdf.TextVertical.
+type glyphsVertical []glyph
+
+func (x glyphsVertical) Len() int      { return len(x) }
+func (x glyphsVertical) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x glyphsVertical) Less(i, j int) bool {
+	if x[i].Y != x[j].Y {
+		return x[i].Y > x[j].Y
+	}
+	return x[i].X < x[j].X
+}
+
+// glyphsHorizontal orders glyphs left to right, then top to bottom, matching
+// pdf.TextHorizontal.
+type glyphsHorizontal []glyph
+
+func (x glyphsHorizontal) Len() int      { return len(x) }
+func (x glyphsHorizontal) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x glyphsHorizontal) Less(i, j int) bool {
+	if x[i].X != x[j].X {
+		return x[i].X < x[j].X
+	}
+	return x[i].Y > x[j].Y
 }
 
-func groupLines(glyphs []pdf.Text) [][]pdf.Text {
-	var lines [][]pdf.Text
-	var line []pdf.Text
+func groupLines(glyphs []glyph) [][]glyph {
+	var lines [][]glyph
+	var line []glyph
 	var anchorY float64
 
 	for _, g := range glyphs {
@@ -114,25 +486,26 @@ func groupLines(glyphs []pdf.Text) [][]pdf.Text {
 			line = append(line, g)
 			continue
 		}
-		sort.Sort(pdf.TextHorizontal(line))
+		sort.Sort(glyphsHorizontal(line))
 		lines = append(lines, line)
-		line = []pdf.Text{g}
+		line = []glyph{g}
 		anchorY = g.Y
 	}
 
 	if len(line) > 0 {
-		sort.Sort(pdf.TextHorizontal(line))
+		sort.Sort(glyphsHorizontal(line))
 		lines = append(lines, line)
 	}
 
 	return lines
 }
 
-func buildWords(line []pdf.Text, page int) []Token {
+func buildWords(line []glyph, page int) []Token {
 	tokens := make([]Token, 0, len(line))
 	var buf strings.Builder
-	var start pdf.Text
-	var last pdf.Text
+	var start glyph
+	var last glyph
+	var box bbox
 	var haveWord bool
 
 	flush := func() {
@@ -147,12 +520,32 @@ func buildWords(line []pdf.Text, page int) []Token {
 			Type:   TokenWord,
 			Lexeme: word,
 			Pos: Position{
-				Page:     page,
-				X:        start.X,
-				Y:        start.Y,
-				Width:    width,
-				Font:     start.Font,
-				FontSize: start.FontSize,
+				Page:        page,
+				X:           start.X,
+				Y:           start.Y,
+				Width:       width,
+				Font:        start.Font,
+				FontSize:    start.FontSize,
+				X0:          box.x0,
+				Y0:          box.y0,
+				X1:          box.x1,
+				Y1:          box.y1,
+				Baseline:    start.baseline,
+				Rotation:    start.rotation,
+				Vertical:    start.vertical,
+				CharSpacing: start.charSpace,
+				Script:      start.script,
+				Monospace:   start.mono,
+				Bold:        start.bold,
+				Watermark:   start.watermark,
+				Element:     start.element,
+				Tag:         start.tag(),
+				Artifact:    start.artifactKind(),
+				RenderMode:  start.mode,
+				Fill:        start.fill.hex(),
+				Clipped:     start.clipped,
+				OffPage:     start.offPage,
+				OCR:         start.ocr,
 			},
 		})
 		buf.Reset()
@@ -174,6 +567,7 @@ func buildWords(line []pdf.Text, page int) []Token {
 		if !haveWord {
 			start = g
 			last = g
+			box = g.box
 			buf.WriteString(ch)
 			haveWord = true
 			continue
@@ -181,11 +575,14 @@ func buildWords(line []pdf.Text, page int) []Token {
 
 		gap := g.X - (last.X + glyphAdvance(last))
 		threshold := math.Max(wordGapFloor, math.Max(last.FontSize, g.FontSize)*wordGapScale)
-		if gap > threshold && !shouldJoinTracked(last, g, gap, threshold) {
+		if g.script != last.script || gap > threshold && !shouldJoinTracked(last, g, gap, threshold) {
 			flush()
 			start = g
+			box = g.box
+			haveWord = true
 		}
 		buf.WriteString(ch)
+		box = box.union(g.box)
 		last = g
 	}
 	flush()
@@ -197,7 +594,7 @@ func cleanGlyphText(s string) string {
 	return strings.TrimSpace(s)
 }
 
-func glyphAdvance(g pdf.Text) float64 {
+func glyphAdvance(g glyph) float64 {
 	if g.W > 0 {
 		return g.W
 	}
@@ -212,7 +609,7 @@ func glyphAdvance(g pdf.Text) float64 {
 	return float64(runes) * g.FontSize * missingWidthScale
 }
 
-func shouldJoinTracked(last, current pdf.Text, gap, threshold float64) bool {
+func shouldJoinTracked(last, current glyph, gap, threshold float64) bool {
 	if last.W > 0 && current.W > 0 {
 		return false
 	}
@@ -243,7 +640,7 @@ func isTrackingToken(s string) bool {
 	return true
 }
 
-func maxFontSize(line []pdf.Text) float64 {
+func maxFontSize(line []glyph) float64 {
 	var max float64
 	for _, g := range line {
 		if g.FontSize > max {
@@ -0,0 +1,883 @@
+package yapp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rc4"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// writeTestPDF writes a minimal PDF with one page per content stream and
+// returns its path. Pages are US Letter; /F1 is Helvetica, /F2 Courier and
+// /Im1 a one-pixel gray image. A content of brokenStream produces a page
+// whose stream uses a filter the pdf package cannot decode. A content that
+// starts with a pageAttrs line adds entries to the page dictionary; one
+// made with catalogAttrs adds entries to the catalog instead of a page.
+// Page n (from 1) is object 5+2n.
+func writeTestPDF(t *testing.T, contents ...string) string {
+	t.Helper()
+	return writeEncryptedTestPDF(t, "", contents...)
+}
+
+// writeEncryptedTestPDF is writeTestPDF with RC4-128 encryption (standard
+// security handler, revision 3) when password is not empty.
+func writeEncryptedTestPDF(t *testing.T, password string, contents ...string) string {
+	t.Helper()
+
+	type object struct {
+		dict   string
+		stream string
+	}
+	var objects []object
+	add := func(dict, stream string) int {
+		objects = append(objects, object{dict, stream})
+		return len(objects)
+	}
+
+	add("", "") // catalog, filled in below
+	add("", "") // page tree, filled in below
+	helvetica := add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding "+testWidths(false)+" >>", "")
+	courier := add("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding "+testWidths(true)+" >>", "")
+	img := add("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x80")
+
+	var kids []string
+	var catalog string
+	for _, content := range contents {
+		if rest, ok := strings.CutPrefix(content, catalogAttrPrefix); ok {
+			catalog += " " + rest
+			continue
+		}
+		var attrs string
+		if rest, ok := strings.CutPrefix(content, pageAttrPrefix); ok {
+			attrs, content, _ = strings.Cut(rest, "\n")
+		}
+		dict := "<<"
+		if content == brokenStream {
+			dict += " /Filter /LZWDecode"
+		}
+		stream := add(dict, content+"\n")
+		page := add(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject << /Im1 %d 0 R >> >> /Contents %d 0 R %s>>", helvetica, courier, img, stream, attrs), "")
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	objects[0].dict = "<< /Type /Catalog /Pages 2 0 R" + catalog + " >>"
+	objects[1].dict = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	id := "0123456789abcdef"
+	var key []byte
+	trailerExtra := ""
+	if password != "" {
+		var encrypt string
+		key, encrypt = testEncryption(password, id)
+		trailerExtra = " /Encrypt " + encrypt
+	}
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		if obj.stream == "" {
+			fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj.dict)
+			continue
+		}
+		data := []byte(obj.stream)
+		if key != nil {
+			h := md5.Sum(append(append([]byte{}, key...), byte(i+1), byte((i+1)>>8), byte((i+1)>>16), 0, 0))
+			c, _ := rc4.NewCipher(h[:])
+			c.XORKeyStream(data, data)
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n%s /Length %d >>\nstream\n%s\nendstream\nendobj\n", i+1, obj.dict, len(data), data)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /ID [<%x> <%x>]%s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, id, id, trailerExtra, xref)
+
+	path := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("write test pdf: %v", err)
+	}
+	return path
+}
+
+const (
+	pageAttrPrefix    = "%page "
+	catalogAttrPrefix = "%catalog "
+)
+
+// pageAttrs prefixes a test page's content with extra page dictionary
+// entries, e.g. pageAttrs("/Rotate 90", content).
+func pageAttrs(attrs, content string) string {
+	return pageAttrPrefix + attrs + "\n" + content
+}
+
+// catalogAttrs makes a writeTestPDF content that adds entries to the
+// catalog, e.g. catalogAttrs("/PageLabels << ... >>").
+func catalogAttrs(attrs string) string {
+	return catalogAttrPrefix + attrs
+}
+
+// testEncryption derives the RC4 file key for a user password (PDF 32000-1
+// algorithms 2 and 5) and returns it with the matching /Encrypt dictionary.
+func testEncryption(password, id string) ([]byte, string) {
+	pad := []byte{
+		0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+		0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+	}
+	owner := bytes.Repeat([]byte{0x42}, 32)
+	p := int32(-4)
+
+	h := md5.New()
+	h.Write(append([]byte(password), pad...)[:32])
+	h.Write(owner)
+	h.Write([]byte{byte(p), byte(p >> 8), byte(p >> 16), byte(p >> 24)})
+	h.Write([]byte(id))
+	key := h.Sum(nil)
+	for i := 0; i < 50; i++ {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+
+	sum := md5.Sum(append(append([]byte{}, pad...), id...))
+	u := sum[:]
+	for i := 0; i <= 19; i++ {
+		k := make([]byte, len(key))
+		for j := range key {
+			k[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(k)
+		c.XORKeyStream(u, u)
+	}
+	u = append(u, make([]byte, 16)...)
+
+	return key, fmt.Sprintf("<< /Filter /Standard /V 2 /R 3 /Length 128 /P %d /O <%x> /U <%x> >>", p, owner, u)
+}
+
+const brokenStream = "%broken%"
+
+// corruptObject renumbers object n of a test PDF in place, so that the pdf
+// package panics when it loads the object.
+func corruptObject(t *testing.T, path string, n int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read test pdf: %v", err)
+	}
+	header := fmt.Sprintf("\n%d 0 obj\n", n)
+	if !bytes.Contains(data, []byte(header)) {
+		t.Fatalf("object %d not found", n)
+	}
+	data = bytes.Replace(data, []byte(header), []byte(fmt.Sprintf("\n%d 1 obj\n", n)), 1)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write test pdf: %v", err)
+	}
+}
+
+// testWidths returns a /Widths array for codes 32-126: a flat 600 for the
+// monospaced font and a rough proportional spread otherwise.
+func testWidths(mono bool) string {
+	var ws []string
+	for c := ' '; c <= '~'; c++ {
+		ws = append(ws, fmt.Sprint(testWidth(c, mono)))
+	}
+	return "/FirstChar 32 /LastChar 126 /Widths [" + strings.Join(ws, " ") + "]"
+}
+
+// testWidth is the width of c in the test fonts, in glyph space units.
+func testWidth(c rune, mono bool) float64 {
+	switch {
+	case mono:
+		return 600
+	case strings.ContainsRune(" .,:;!|ijlft'", c):
+		return 278
+	case strings.ContainsRune("mwMW", c):
+		return 833
+	}
+	return 556
+}
+
+func TestInvisibleTextModes(t *testing.T) {
+	mixed := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (Visible body) Tj ET "+
+		"BT 3 Tr /F1 12 Tf 72 650 Td (Hidden junk) Tj ET "+
+		"q 0 0 100 100 re W n BT /F1 12 Tf 300 500 Td (Clipped away) Tj ET Q")
+	ocr := writeTestPDF(t, "BT 3 Tr /F1 12 Tf 72 700 Td (Scanned words) Tj ET")
+
+	cases := []struct {
+		name string
+		path string
+		mode InvisibleText
+		want []string
+		drop []string
+	}{
+		{"auto drops hidden text", mixed, InvisibleAuto, []string{"Visible body"}, []string{"Hidden", "Clipped"}},
+		{"include keeps everything", mixed, InvisibleInclude, []string{"Visible body", "Hidden junk", "Clipped away"}, nil},
+		{"exclude drops hidden text", mixed, InvisibleExclude, []string{"Visible body"}, []string{"Hidden", "Clipped"}},
+		{"auto keeps OCR layer", ocr, InvisibleAuto, []string{"Scanned words"}, nil},
+		{"exclude drops OCR layer", ocr, InvisibleExclude, nil, []string{"Scanned"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := NewLexerWithOptions(tc.path, Options{InvisibleText: tc.mode}).Tokenize()
+			if err != nil {
+				t.Fatalf("tokenize: %v", err)
+			}
+			text := tokenText(tokens)
+			for _, want := range tc.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text %q missing %q", text, want)
+				}
+			}
+			for _, drop := range tc.drop {
+				if strings.Contains(text, drop) {
+					t.Errorf("text %q should not contain %q", text, drop)
+				}
+			}
+		})
+	}
+}
+
+func TestPositionRecordsRenderState(t *testing.T) {
+	path := writeTestPDF(t, "BT 1 0 0 rg 3 Tr /F1 12 Tf 72 700 Td (Red) Tj ET")
+	tokens, err := NewLexerWithOptions(path, Options{InvisibleText: InvisibleInclude}).Tokenize()
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	if tokens[0].Type != TokenWord {
+		t.Fatalf("first token = %+v, want a word", tokens[0])
+	}
+	pos := tokens[0].Pos
+	if pos.RenderMode != 3 || pos.Fill != "#ff0000" || !pos.Invisible() {
+		t.Fatalf("position = %+v, want invisible red text", pos)
+	}
+}
+
+func TestPositionGeometry(t *testing.T) {
+	path := writeTestPDF(t,
+		"BT /F2 10 Tf 2 Tc 5 Ts 72 700 Td (Up) Tj ET",
+		"BT /F2 10 Tf 0 1 -1 0 300 400 Tm (A) Tj ET",
+	)
+	tokens, err := NewLexer(path).Tokenize()
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	var words []Position
+	for _, tok := range tokens {
+		if tok.Type == TokenWord {
+			words = append(words, tok.Pos)
+		}
+	}
+	if len(words) != 2 {
+		t.Fatalf("got %d words, want 2", len(words))
+	}
+
+	// Courier has no descriptor here, so the default 0.75/-0.25 em apply.
+	up := words[0]
+	want := Position{X0: 72, Y0: 702.5, X1: 86, Y1: 712.5, Baseline: 700, CharSpacing: 2}
+	if up.X0 != want.X0 || up.Y0 != want.Y0 || up.X1 != want.X1 || up.Y1 != want.Y1 ||
+		up.Baseline != want.Baseline || up.CharSpacing != want.CharSpacing || up.Y != 705 {
+		t.Errorf("raised word = %+v, want geometry %+v", up, want)
+	}
+
+	a := words[1]
+	if a.Rotation != 90 || a.X0 != 292.5 || a.X1 != 302.5 || a.Y0 != 400 || a.Y1 != 406 {
+		t.Errorf("rotated word = %+v", a)
+	}
+}
+
+func TestRotatedText(t *testing.T) {
+	path := writeTestPDF(t,
+		pageAttrs("/Rotate 90", "BT /F1 12 Tf 0 1 -1 0 100 100 Tm (First line) Tj ET "+
+			"BT /F1 12 Tf 0 1 -1 0 114 100 Tm (second line) Tj ET"),
+		"BT /F1 12 Tf 72 700 Td (Body text) Tj ET "+
+			"BT /F1 10 Tf 0 1 -1 0 500 300 Tm (Rotated header cell) Tj ET "+
+			"BT /F1 12 Tf 72 686 Td (continues here) Tj ET",
+	)
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "## Page 1\n\nFirst line second line\n\n\n## Page 2\n\nBody text continues here\n\nRotated header cell\n"
+	if result.Markdown != want {
+		t.Fatalf("markdown =\n%q\nwant\n%q", result.Markdown, want)
+	}
+	span := result.AST.Pages[1].Blocks[1].Lines[0].Spans[0]
+	if span.Pos.Rotation != 90 || span.Pos.X != 500 || span.Pos.Y != 300 {
+		t.Errorf("rotated span position = %+v, want page coordinates and rotation 90", span.Pos)
+	}
+}
+
+func TestVerticalText(t *testing.T) {
+	// /F3 writes vertically; without widths its glyphs are 0.6 em wide.
+	path := writeTestPDF(t, pageAttrs(
+		"/Resources << /Font << /F1 3 0 R /F3 << /Type /Font /Subtype /Type0 /BaseFont /Mincho /Encoding /Identity-V >> >> >>",
+		"BT /F1 10 Tf 72 700 Td (Body text) Tj ET "+
+			"BT /F3 10 Tf 480 700 Td (NEXT) Tj ET BT /F3 10 Tf 500 700 Td (TATE) Tj ET"))
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// Columns read top to bottom and right to left, after the horizontal text.
+	if want := "Body text\n\nTATE NEXT\n"; result.Markdown != want {
+		t.Fatalf("markdown = %q, want %q", result.Markdown, want)
+	}
+
+	// A vertical origin is centred 0.88 em above the glyph's cell, so four
+	// 10 pt glyphs set from 500,700 fill x 497 to 503 and y 698.7
+	// (700 - 8.8 + 7.5) down to 658.7 (670 - 8.8 - 2.5).
+	span := result.AST.Pages[0].Blocks[1].Lines[0].Spans[0]
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	p := span.Pos
+	if span.Text != "TATE" || !p.Vertical || p.X != 500 || p.Y != 700 || p.Width != 40 ||
+		!near(p.X0, 497) || !near(p.X1, 503) || !near(p.Y0, 658.7) || !near(p.Y1, 698.7) {
+		t.Errorf("first vertical span = %q at %+v, want TATE from 500,700 in 497,658.7-503,698.7", span.Text, p)
+	}
+}
+
+func TestLogicalOrder(t *testing.T) {
+	words := func(lexemes ...string) []Token {
+		var tokens []Token
+		for i, s := range lexemes {
+			tokens = append(tokens, Token{Type: TokenWord, Lexeme: s, Pos: Position{X: float64(i * 40)}})
+		}
+		return tokens
+	}
+	for _, tc := range []struct {
+		name    string
+		visual  []Token
+		logical string
+	}{
+		{"latin", words("plain", "text"), "plain text"},
+		{"hebrew", words("םלוע", "םולש"), "שלום עולם"},
+		{"numerals", words("םירפס", "123", "שי"), "יש 123 ספרים"},
+		{"arabic with number", words("2024", "ماع"), "عام 2024"},
+		{"latin in hebrew", words("םלוע", "ok", "go", "םולש"), "שלום ok go עולם"},
+		{"hebrew in latin", words("say", "םולש", "now"), "say שלום now"},
+		{"mirrored", words("(םולש)"), "(שלום)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := logicalOrder(tc.visual)
+			var lexemes []string
+			for _, tok := range got {
+				lexemes = append(lexemes, tok.Lexeme)
+			}
+			if s := strings.Join(lexemes, " "); s != tc.logical {
+				t.Errorf("logical order = %q, want %q", s, tc.logical)
+			}
+		})
+	}
+
+	doc := NewParser(append(words("םולש", "שי", "end"), Token{Type: TokenNewline})).Parse()
+	if doc.Direction != DirectionRTL || doc.Pages[0].Blocks[0].Direction != DirectionRTL {
+		t.Errorf("direction = %q/%q, want rtl", doc.Direction, doc.Pages[0].Blocks[0].Direction)
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		n     normalizer
+		in    string
+		want  string
+		count int
+	}{
+		{"ligatures", newNormalizer(NormalizeNFC, nil), "ﬁleﬂow", "fileflow", 2},
+		{"nfc", newNormalizer(NormalizeNFC, nil), "cafe\u0301", "café", 1},
+		{"nfc keeps full width", newNormalizer(NormalizeNFC, nil), "ＡＢ１", "ＡＢ１", 0},
+		{"nfkc", newNormalizer(NormalizeNFKC, nil), "ＡＢ１", "AB1", 1},
+		{"none", newNormalizer(NormalizeNone, nil), "cafe\u0301", "cafe\u0301", 0},
+		{"private use bullet", newNormalizer(NormalizeNFC, nil), "\uF0B7", "•", 1},
+		{"custom map", newNormalizer(NormalizeNFC, map[rune]string{'ﬁ': "ﬁ", '\uE000': "Ω"}), "ﬁ\uE000", "ﬁΩ", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, count := tc.n.apply(tc.in)
+			if got != tc.want || count != tc.count {
+				t.Errorf("apply(%q) = %q, %d; want %q, %d", tc.in, got, count, tc.want, tc.count)
+			}
+		})
+	}
+
+	table := GlyphReplacements()
+	table['ﬁ'] = "X"
+	if got, _ := newNormalizer(NormalizeNFC, nil).apply("ﬁ"); got != "fi" {
+		t.Errorf("changing the GlyphReplacements copy changed the table: got %q", got)
+	}
+
+	// A soft hyphen (\255 in WinAnsi) at a line break joins the word up
+	// again; one inside a line is dropped.
+	path := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (A worked exam\\255) Tj ET "+
+		"BT /F1 12 Tf 72 686 Td (ple of co\\255operation.) Tj ET")
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "A worked example of cooperation.\n"; result.Markdown != want {
+		t.Errorf("markdown = %q, want %q", result.Markdown, want)
+	}
+}
+
+func TestFakeBold(t *testing.T) {
+	// Each glyph twice, the copy 0.3pt to the right of it: "HHeelllloo".
+	// Td moves from the start of the previous copy, so the next letter
+	// starts one advance after the first copy.
+	var tj strings.Builder
+	for _, c := range "Hello" {
+		fmt.Fprintf(&tj, "(%c) Tj 0.3 0 Td (%c) Tj %.3f 0 Td ", c, c, testWidth(c, false)*12/1000-0.3)
+	}
+	path := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td "+tj.String()+"ET "+
+		"BT 0.5 g /F1 12 Tf 72 680 Td (Shadow all) Tj ET BT 0 g /F1 12 Tf 72.4 680.4 Td (Shadow all) Tj ET "+
+		"BT /F1 12 Tf 72 660 Td (plain) Tj ET")
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "Hello Shadow all plain\n"; result.Markdown != want {
+		t.Fatalf("markdown = %q, want %q", result.Markdown, want)
+	}
+	var lines []LineNode
+	for _, b := range result.AST.Pages[0].Blocks {
+		lines = append(lines, b.Lines...)
+	}
+	if s := lines[0].Spans[0]; !s.Pos.Bold || s.Pos.X != 72.3 || s.Text != "Hello" {
+		t.Errorf("Hello = %+v, want the bold top copy", s)
+	}
+	if s := lines[1].Spans[0]; !s.Pos.Bold || s.Pos.Fill != "#000000" {
+		t.Errorf("Shadow = %+v, want the black top copy, bold", s.Pos)
+	}
+	if s := lines[2].Spans[0]; s.Pos.Bold {
+		t.Errorf("plain = %+v, want not bold", s.Pos)
+	}
+
+	// A dense page of one letter keeps every glyph, and a third copy drawn
+	// near the second finds it.
+	var dense []glyph
+	for y := 780.0; y > 0; y -= 4 {
+		for x := 0.0; x < 600; x += 3 {
+			dense = append(dense, glyph{Text: pdf.Text{Font: "Helvetica", FontSize: 6, X: x, Y: y, W: 3, S: "e"}})
+		}
+	}
+	n := len(dense)
+	dense = append(dense,
+		glyph{Text: pdf.Text{Font: "Helvetica", FontSize: 6, X: 1.5e4, Y: 3.9, W: 3, S: "e"}},
+		glyph{Text: pdf.Text{Font: "Helvetica", FontSize: 6, X: 1.5e4 + 0.3, Y: 4.2, W: 3, S: "e"}},
+		glyph{Text: pdf.Text{Font: "Helvetica", FontSize: 6, X: 1.5e4 + 0.6, Y: 4.5, W: 3, S: "e"}})
+	if got := collapseOverdraw(dense); len(got) != n+1 || !got[n].bold || got[n].X != 1.5e4+0.6 {
+		t.Errorf("dense page: kept %d of %d glyphs, last %+v", len(got), n+3, got[len(got)-1])
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	page := func(body string) string {
+		return "BT /F1 10 Tf 72 760 Td (ACME Corp) Tj ET " +
+			"q 0.8 g BT /F1 72 Tf 0.7071 0.7071 -0.7071 0.7071 200 300 Tm (DRAFT) Tj ET Q " +
+			"BT /F1 12 Tf 72 700 Td (" + body + ") Tj ET"
+	}
+	path := writeTestPDF(t,
+		page("First page text"),
+		page("Second page text")+" /Artifact << /Type /Pagination /Subtype /Watermark >> BDC "+
+			"BT /F1 12 Tf 72 600 Td (Internal use) Tj ET EMC",
+	)
+
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "## Page 1\n\nACME Corp First page text\n\n\n## Page 2\n\nACME Corp Second page text\n"
+	if result.Markdown != want {
+		t.Fatalf("markdown =\n%q\nwant\n%q", result.Markdown, want)
+	}
+	if result.Pages[0].Watermarks != 1 || result.Pages[1].Watermarks != 2 {
+		t.Errorf("watermarks = %d, %d; want 1, 2", result.Pages[0].Watermarks, result.Pages[1].Watermarks)
+	}
+
+	result, err = ParseFileWithOptions(path, Options{KeepWatermarks: true})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var marked []string
+	for _, b := range result.AST.Pages[1].Blocks {
+		for _, l := range b.Lines {
+			for _, s := range l.Spans {
+				if s.Pos.Watermark {
+					marked = append(marked, s.Text)
+				}
+			}
+		}
+	}
+	if strings.Join(marked, " ") != "Internal use DRAFT" {
+		t.Errorf("kept watermark spans = %q, want Internal use DRAFT", marked)
+	}
+
+	// Repeated text that is not large stays: a white running header, a
+	// rotated table header and a slightly skewed line.
+	page = func(body string) string {
+		return "q 1 g BT /F1 10 Tf 72 760 Td (Annual Review) Tj ET Q " +
+			"BT /F1 10 Tf 0 1 -1 0 300 500 Tm (Region) Tj ET " +
+			"BT /F1 12 Tf 0.9994 0.0349 -0.0349 0.9994 72 650 Tm (Signed copy) Tj ET " +
+			"BT /F1 12 Tf 72 700 Td (" + body + ") Tj ET"
+	}
+	path = writeTestPDF(t, page("First page text"), page("Second page text"))
+	result, err = ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, text := range []string{"Annual Review", "Region", "Signed copy"} {
+		if !strings.Contains(result.Markdown, text) {
+			t.Errorf("%q dropped as a watermark:\n%s", text, result.Markdown)
+		}
+	}
+	if result.Pages[0].Watermarks != 0 {
+		t.Errorf("watermarks = %d, want 0", result.Pages[0].Watermarks)
+	}
+
+	// A light grey stamp repeated on every page goes whatever its size.
+	page = func(body string) string {
+		return "q 0.8 g BT /F1 12 Tf 250 400 Td (CONFIDENTIAL) Tj ET Q " +
+			"BT /F1 12 Tf 72 700 Td (" + body + ") Tj ET"
+	}
+	path = writeTestPDF(t, page("First page text"), page("Second page text"))
+	result, err = ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if strings.Contains(result.Markdown, "CONFIDENTIAL") || result.Pages[1].Watermarks != 1 {
+		t.Errorf("body-size grey stamp kept, %d watermarks:\n%s", result.Pages[1].Watermarks, result.Markdown)
+	}
+
+	// An index into an Indexed palette is not a light colour.
+	glyphs := []glyph{
+		{Text: pdf.Text{Font: "F1", FontSize: 10, X: 72, Y: 700, W: 5, S: "a"}},
+		{Text: pdf.Text{Font: "F1", FontSize: 10, X: 77, Y: 700, W: 5, S: "b"}},
+		{Text: pdf.Text{Font: "F1", FontSize: 40, X: 72, Y: 400, W: 20, S: "X"}, fill: rgb{1, 1, 1}, rawFill: true},
+	}
+	if n := markWatermarks(glyphs, nil); n != 0 {
+		t.Errorf("large text in a palette colour counted as %d watermarks", n)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	path := writeTestPDF(t,
+		"/Artifact << /Type /Pagination /Subtype /Header >> BDC BT /F1 10 Tf 72 760 Td (Quarterly Report) Tj ET EMC "+
+			"/P << /MCID 0 >> BDC BT /F1 12 Tf 72 700 Td (Body text) Tj ET EMC "+
+			"/Artifact BMC BT /F1 10 Tf 300 40 Td (1) Tj ET EMC")
+
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if result.Markdown != "Body text\n" {
+		t.Fatalf("markdown = %q, want only the body text", result.Markdown)
+	}
+
+	result, err = ParseFileWithOptions(path, Options{KeepArtifacts: true})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := make(map[string]Position)
+	for _, b := range result.AST.Pages[0].Blocks {
+		for _, l := range b.Lines {
+			for _, s := range l.Spans {
+				got[s.Text] = s.Pos
+			}
+		}
+	}
+	for text, want := range map[string][2]string{
+		"Quarterly": {"Artifact", "Header"},
+		"Body":      {"P", ""},
+		"1":         {"Artifact", "Artifact"},
+	} {
+		if p := got[text]; p.Tag != want[0] || p.Artifact != want[1] {
+			t.Errorf("%s: tag %q, artifact %q; want %q, %q", text, p.Tag, p.Artifact, want[0], want[1])
+		}
+	}
+}
+
+func TestPageSelection(t *testing.T) {
+	for in, want := range map[string]string{
+		"1-3,7,10-": "1-3,7,10-",
+		" 2 , 5-5 ": "2,5",
+		"-4":        "1-4",
+	} {
+		sel, err := ParsePageSelection(in)
+		if err != nil || sel.String() != want {
+			t.Errorf("ParsePageSelection(%q) = %v, %v; want %s", in, sel, err, want)
+		}
+	}
+	for _, in := range []string{"", "x", "0", "3-1", "-", "1-2-3"} {
+		if sel, err := ParsePageSelection(in); err == nil {
+			t.Errorf("ParsePageSelection(%q) = %v, want an error", in, sel)
+		}
+	}
+
+	// Page 1 cannot be decoded; leaving it out must not even try.
+	path := writeTestPDF(t, brokenStream,
+		"BT /F1 12 Tf 72 700 Td (Second) Tj ET",
+		"BT /F1 12 Tf 72 700 Td (Third) Tj ET",
+		"BT /F1 12 Tf 72 700 Td (Fourth) Tj ET")
+	sel, _ := ParsePageSelection("2,4-")
+	result, err := ParseFileWithOptions(path, Options{Pages: sel})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "## Page 2\n\nSecond\n\n\n## Page 4\n\nFourth\n"; result.Markdown != want {
+		t.Errorf("markdown = %q, want %q", result.Markdown, want)
+	}
+	if len(result.Pages) != 2 || result.Pages[0].Number != 2 || result.Pages[1].Number != 4 {
+		t.Errorf("page reports = %+v, want pages 2 and 4", result.Pages)
+	}
+
+	sel, _ = ParsePageSelection("9-")
+	if _, err := ParseFileWithOptions(path, Options{Pages: sel}); err == nil {
+		t.Error("selecting no existing page succeeded")
+	}
+}
+
+func TestPageGeometry(t *testing.T) {
+	path := writeTestPDF(t,
+		catalogAttrs("/PageLabels << /Nums [0 << /S /r /St 3 >> 2 << /S /D /P (A-) >>] >>"),
+		"BT /F1 12 Tf 72 700 Td (Preface) Tj ET",
+		pageAttrs("/CropBox [36 36 576 756] /Rotate 90", "BT /F1 12 Tf 72 500 Td (Foreword) Tj ET"),
+		"BT /F1 12 Tf 72 700 Td (Chapter) Tj ET")
+
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "## Page iii\n\nPreface\n\n\n## Page iv\n\nForeword\n\n\n## Page A-1\n\nChapter\n"; result.Markdown != want {
+		t.Errorf("markdown = %q, want %q", result.Markdown, want)
+	}
+	if len(result.AST.Pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(result.AST.Pages))
+	}
+	// Boxes are in page space: turned with the page.
+	want := PageGeometry{Label: "iv", Width: 720, Height: 540, MediaBox: Box{0, 0, 792, 612}, CropBox: Box{36, 36, 756, 576}, Rotation: 90}
+	if got := result.AST.Pages[1].PageGeometry; got != want {
+		t.Errorf("page 2 geometry = %+v, want %+v", got, want)
+	}
+	if got := result.AST.Pages[0]; got.Width != 612 || got.Height != 792 || got.CropBox != got.MediaBox {
+		t.Errorf("page 1 geometry = %+v, want the MediaBox", got.PageGeometry)
+	}
+
+	// Page space starts at the MediaBox corner, and chunk boxes are in it.
+	path = writeTestPDF(t, pageAttrs("/MediaBox [100 100 712 892] /CropBox [150 150 662 842]",
+		"BT /F1 12 Tf 172 800 Td (Offset) Tj ET"))
+	result, err = ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	page := result.AST.Pages[0]
+	if page.MediaBox != (Box{0, 0, 612, 792}) || page.CropBox != (Box{50, 50, 562, 742}) {
+		t.Errorf("offset page geometry = %+v", page.PageGeometry)
+	}
+	if pos := page.Blocks[0].Lines[0].Spans[0].Pos; pos.X != 72 || pos.Y != 700 {
+		t.Errorf("offset text at %v,%v, want 72,700", pos.X, pos.Y)
+	}
+	if box := result.Chunks(ChunkOptions{})[0].BBoxes[0]; box.X0 != 72 || box.Y0 >= 700 || box.Y1 <= 700 {
+		t.Errorf("chunk box = %+v, want it around 72,700", box)
+	}
+
+	// Labels and boxes the pdf package cannot load are left out. Object 8
+	// is page 2's content stream.
+	path = writeTestPDF(t, catalogAttrs("/PageLabels 8 0 R"),
+		pageAttrs("/CropBox 8 0 R", "BT /F1 12 Tf 72 700 Td (Preface) Tj ET"),
+		"BT /F1 12 Tf 72 700 Td (Lost) Tj ET")
+	corruptObject(t, path, 8)
+	result, err = ParseFileWithOptions(path, Options{Lenient: true})
+	if err != nil {
+		t.Fatalf("parse with broken labels: %v", err)
+	}
+	want = PageGeometry{Width: 612, Height: 792, MediaBox: Box{0, 0, 612, 792}, CropBox: Box{0, 0, 612, 792}}
+	if len(result.AST.Pages) != 1 || result.AST.Pages[0].PageGeometry != want {
+		t.Errorf("pages = %+v, want page 1 with its MediaBox", result.AST.Pages)
+	}
+
+	for n, want := range map[int]string{1: "i", 4: "iv", 14: "xiv", 1994: "mcmxciv"} {
+		if got := roman(n); got != want {
+			t.Errorf("roman(%d) = %q, want %q", n, got, want)
+		}
+	}
+	for n, want := range map[int]string{1: "a", 26: "z", 27: "aa", 53: "aaa"} {
+		if got := letters(n); got != want {
+			t.Errorf("letters(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestOffPageText(t *testing.T) {
+	path := writeTestPDF(t, pageAttrs("/CropBox [36 36 576 756]",
+		"BT /F1 12 Tf 72 700 Td (Body text) Tj ET "+
+			"BT /F1 6 Tf 40 770 Td (Job 4711) Tj ET "+
+			"BT /F1 6 Tf 600 400 Td (Proof) Tj ET"))
+
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if result.Markdown != "Body text\n" {
+		t.Errorf("markdown = %q, want the body only", result.Markdown)
+	}
+	if got := result.Pages[0].OffPage; got != 13 {
+		t.Errorf("off-page characters = %d, want 13", got)
+	}
+
+	tokens, err := NewLexerWithOptions(path, Options{KeepOffPage: true}).Tokenize()
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	offPage := make(map[string]bool)
+	for _, tok := range tokens {
+		if tok.Type == TokenWord {
+			offPage[tok.Lexeme] = tok.Pos.OffPage
+		}
+	}
+	if offPage["Body"] || !offPage["Job"] || !offPage["4711"] || !offPage["Proof"] {
+		t.Errorf("off-page words = %v, want Job 4711 Proof", offPage)
+	}
+}
+
+func TestPageReports(t *testing.T) {
+	path := writeTestPDF(t,
+		"BT /F1 12 Tf 72 700 Td (Two words) Tj ET",
+		"q 612 0 0 792 0 0 cm /Im1 Do Q",
+		"0 0 m 100 100 l S",
+		brokenStream,
+	)
+	lexer := NewLexerWithOptions(path, Options{Lenient: true})
+	if _, err := lexer.Tokenize(); err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	want := []PageStatus{PageText, PageImageOnly, PageEmpty, PageDecodeError}
+	reports := lexer.Reports()
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(reports), len(want), reports)
+	}
+	for i, r := range reports {
+		if r.Number != i+1 || r.Status != want[i] {
+			t.Errorf("page %d report = %+v, want status %s", i+1, r, want[i])
+		}
+	}
+	if reports[0].Words != 2 || reports[1].Images != 1 || reports[3].Error == "" {
+		t.Errorf("reports = %+v", reports)
+	}
+}
+
+func TestMalformedInput(t *testing.T) {
+	broken := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (Fine) Tj ET", brokenStream)
+
+	_, err := ParseFile(broken)
+	var pageErr *PageError
+	if !errors.As(err, &pageErr) || pageErr.Page != 2 || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("strict parse: err = %v, want corrupt PageError for page 2", err)
+	}
+
+	res, err := ParseFileWithOptions(broken, Options{Lenient: true})
+	if err != nil {
+		t.Fatalf("lenient parse: %v", err)
+	}
+	if !strings.Contains(res.Markdown, "Fine") {
+		t.Fatalf("lenient markdown = %q, want page 1 text", res.Markdown)
+	}
+	if len(res.PageErrors) != 1 || res.PageErrors[0].Page != 2 {
+		t.Fatalf("PageErrors = %v, want page 2", res.PageErrors)
+	}
+
+	// A page whose content decodes but whose marked-content properties
+	// cannot be loaded fails later, when its artifacts are looked at.
+	// Object 3 is Helvetica and object 5 the image, loaded only here.
+	lateBroken := writeTestPDF(t,
+		pageAttrs("/Resources << /Font << /F1 3 0 R >> /Properties << /P1 << /Subtype 5 0 R >> >> >>",
+			"/Artifact /P1 BDC BT /F1 12 Tf 72 700 Td (Stamp) Tj ET EMC"),
+		"BT /F1 12 Tf 72 700 Td (Fine) Tj ET")
+	corruptObject(t, lateBroken, 5)
+	if res, err = ParseFileWithOptions(lateBroken, Options{Lenient: true}); err != nil {
+		t.Fatalf("lenient parse of late failure: %v", err)
+	}
+	if len(res.PageErrors) != 1 || res.PageErrors[0].Page != 1 || res.Pages[0].Status != PageDecodeError ||
+		!strings.Contains(res.Markdown, "Fine") {
+		t.Fatalf("late failure: PageErrors = %v, pages = %+v, markdown = %q", res.PageErrors, res.Pages, res.Markdown)
+	}
+
+	notPDF := filepath.Join(t.TempDir(), "notes.pdf")
+	if err := os.WriteFile(notPDF, []byte("just some text\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFile(notPDF); !errors.Is(err, ErrNotPDF) {
+		t.Fatalf("parse text file: err = %v, want ErrNotPDF", err)
+	}
+
+	badXref := filepath.Join(t.TempDir(), "badXref.pdf")
+	data, err := os.ReadFile(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("xref\n"), []byte("xrxf\n"), 1)
+	if err := os.WriteFile(badXref, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFile(badXref); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("parse broken xref: err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestEncryptedPDF(t *testing.T) {
+	path := writeEncryptedTestPDF(t, "s3cret", "BT /F1 12 Tf 72 700 Td (Privileged disclosure) Tj ET")
+
+	res, err := ParseFileWithOptions(path, Options{Password: "s3cret"})
+	if err != nil {
+		t.Fatalf("parse with password: %v", err)
+	}
+	if !strings.Contains(res.Markdown, "Privileged disclosure") {
+		t.Fatalf("markdown = %q, want decrypted text", res.Markdown)
+	}
+
+	if _, err := ParseFile(path); !errors.Is(err, ErrPasswordRequired) || !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("parse without password: err = %v, want ErrPasswordRequired", err)
+	}
+	if _, err := ParseFileWithOptions(path, Options{Password: "wrong"}); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("parse with wrong password: err = %v, want ErrInvalidPassword", err)
+	}
+
+	broken := writeEncryptedTestPDF(t, "s3cret", "BT /F1 12 Tf 72 700 Td (Fine) Tj ET", brokenStream)
+	res, err = ParseFileWithOptions(broken, Options{Password: "s3cret", Lenient: true})
+	if err != nil {
+		t.Fatalf("parse broken page: %v", err)
+	}
+	if got := res.Pages[1].Status; got != PageDecodeError {
+		t.Errorf("broken page of an encrypted pdf: status %q, want %q", got, PageDecodeError)
+	}
+	err = func() (err error) {
+		defer recoverCorrupt(&err)
+		panic("Encrypted text shorter that AES block size")
+	}()
+	if !errors.Is(err, ErrEncrypted) || errors.Is(err, ErrCorrupt) {
+		t.Errorf("decryption panic: err = %v, want ErrEncrypted", err)
+	}
+}
+
+// tokenText joins word tokens with spaces and lines with newlines.
+func tokenText(tokens []Token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		switch tok.Type {
+		case TokenWord:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString(" ")
+			}
+			b.WriteString(tok.Lexeme)
+		case TokenNewline:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
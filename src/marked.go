@@ -0,0 +1,73 @@
+package yapp
+
+import "github.com/ledongthuc/pdf"
+
+// markedContent is an open BMC or BDC sequence: its tag and, for BDC, its
+// property list.
+type markedContent struct {
+	tag   string
+	props pdf.Value
+}
+
+// artifact returns the property list of the innermost /Artifact sequence
+// the glyph is in.
+func (g glyph) artifact() (props pdf.Value, ok bool) {
+	for i := len(g.marked) - 1; i >= 0; i-- {
+		if g.marked[i].tag == "Artifact" {
+			return g.marked[i].props, true
+		}
+	}
+	return pdf.Value{}, false
+}
+
+// mcid returns the marked-content identifier of the innermost sequence
+// around the glyph that has one, linking the glyph to the structure tree.
+func (g glyph) mcid() (int, bool) {
+	for i := len(g.marked) - 1; i >= 0; i-- {
+		if id := g.marked[i].props.Key("MCID"); id.Kind() == pdf.Integer {
+			return int(id.Int64()), true
+		}
+	}
+	return 0, false
+}
+
+// tag returns the tag of the innermost marked-content sequence around the
+// glyph, or "" outside marked content.
+func (g glyph) tag() string {
+	if len(g.marked) == 0 {
+		return ""
+	}
+	return g.marked[len(g.marked)-1].tag
+}
+
+// artifactKind says what kind of artifact the glyph is part of: the
+// artifact's /Subtype (Header, Footer, Watermark) or /Type (Pagination,
+// Layout, Page, Background), or "Artifact" when it has neither. Untagged
+// running footers are "Footer". It is "" for real content.
+func (g glyph) artifactKind() string {
+	props, ok := g.artifact()
+	switch {
+	case !ok && g.footer:
+		return "Footer"
+	case !ok:
+		return ""
+	case props.Key("Subtype").Kind() == pdf.Name:
+		return props.Key("Subtype").Name()
+	case props.Key("Type").Kind() == pdf.Name:
+		return props.Key("Type").Name()
+	}
+	return "Artifact"
+}
+
+// dropArtifacts removes the glyphs marked as artifacts: running headers
+// and footers, page numbers and decorations, and the running footers
+// markFooters found. Watermark artifacts are left to markWatermarks.
+func dropArtifacts(glyphs []glyph) []glyph {
+	kept := make([]glyph, 0, len(glyphs))
+	for _, g := range glyphs {
+		if g.artifactKind() == "" || watermarkArtifact(g) {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
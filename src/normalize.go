@@ -0,0 +1,155 @@
+package yapp
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization selects the Unicode normalization applied to extracted
+// text.
+type Normalization int
+
+const (
+	// NormalizeNFC composes base letters and combining accents, so "é" is
+	// one character whichever way the PDF spelled it.
+	NormalizeNFC Normalization = iota
+	// NormalizeNFKC also folds compatibility characters into their plain
+	// forms: full-width letters and digits, superscript digits, ellipses.
+	NormalizeNFKC
+	// NormalizeNone leaves text as extracted, apart from GlyphMap and soft
+	// hyphens.
+	NormalizeNone
+)
+
+// softHyphen marks where a word may be broken across lines. Words keep it
+// only at the end of a line, where it marks a broken word for the renderer
+// to join up again; elsewhere it is dropped.
+const softHyphen = "\u00AD"
+
+// glyphReplacements maps characters that get in the way of search to the
+// text they stand for: ligatures, private-use code points that symbol
+// fonts put their bullets on, and invisible characters. Options.GlyphMap
+// adds to and overrides it per parse.
+var glyphReplacements = map[rune]string{
+	'ﬀ': "ff",
+	'ﬁ': "fi",
+	'ﬂ': "fl",
+	'ﬃ': "ffi",
+	'ﬄ': "ffl",
+	'ﬅ': "st",
+	'ﬆ': "st",
+	'Ĳ': "IJ",
+	'ĳ': "ij",
+
+	'\uF0B7': "•", // Symbol bullet
+	'\uF0A7': "▪", // Wingdings square bullet
+	'\uF076': "❖", // Wingdings diamond
+	'\uF0D8': "➢", // Wingdings arrowhead
+	'\uF0FC': "✓", // Wingdings check mark
+
+	'\u200B': "", // zero width space
+	'\uFEFF': "", // byte order mark
+}
+
+// GlyphReplacements returns a copy of the built-in table of characters
+// replaced in extracted text: ligatures, private-use code points that symbol
+// fonts put their bullets on, and invisible characters. Changing it has no
+// effect; use Options.GlyphMap to add to or override the table.
+func GlyphReplacements() map[rune]string {
+	table := make(map[rune]string, len(glyphReplacements))
+	for r, s := range glyphReplacements {
+		table[r] = s
+	}
+	return table
+}
+
+// normalizer rewrites the text of word tokens.
+type normalizer struct {
+	form  Normalization
+	table map[rune]string
+}
+
+func newNormalizer(form Normalization, extra map[rune]string) normalizer {
+	table := glyphReplacements
+	if len(extra) > 0 {
+		table = make(map[rune]string, len(glyphReplacements)+len(extra))
+		for r, s := range glyphReplacements {
+			table[r] = s
+		}
+		for r, s := range extra {
+			table[r] = s
+		}
+	}
+	return normalizer{form: form, table: table}
+}
+
+// apply returns s with its characters replaced from the table and then
+// normalized, and how many replacements that took: one per table entry
+// used, plus one if normalization changed the text.
+func (n normalizer) apply(s string) (string, int) {
+	count := 0
+	if strings.IndexFunc(s, func(r rune) bool { _, ok := n.table[r]; return ok }) >= 0 {
+		var b strings.Builder
+		for _, r := range s {
+			if rep, ok := n.table[r]; ok && rep != string(r) {
+				b.WriteString(rep)
+				count++
+				continue
+			}
+			b.WriteRune(r)
+		}
+		s = b.String()
+	}
+
+	var form norm.Form
+	switch n.form {
+	case NormalizeNFC:
+		form = norm.NFC
+	case NormalizeNFKC:
+		form = norm.NFKC
+	default:
+		return s, count
+	}
+	if !form.IsNormalString(s) {
+		s = form.String(s)
+		count++
+	}
+	return s, count
+}
+
+// normalizeTokens runs the words of a page through n and trims their soft
+// hyphens, dropping the words left empty, and returns how many
+// replacements were made.
+func normalizeTokens(tokens []Token, n normalizer) ([]Token, int) {
+	total := 0
+	out := tokens[:0]
+	for i, tok := range tokens {
+		if tok.Type == TokenWord {
+			text, count := n.apply(tok.Lexeme)
+			total += count
+			lineEnd := i+1 == len(tokens) || tokens[i+1].Type == TokenNewline
+			text = trimSoftHyphens(text, lineEnd)
+			if text = strings.TrimSpace(text); text == "" {
+				continue
+			}
+			tok.Lexeme = text
+		}
+		out = append(out, tok)
+	}
+	return out, total
+}
+
+// trimSoftHyphens drops the soft hyphens in a word but for one ending it at
+// the end of a line, where the word is broken.
+func trimSoftHyphens(s string, lineEnd bool) string {
+	if !strings.Contains(s, softHyphen) {
+		return s
+	}
+	broken := lineEnd && strings.HasSuffix(s, softHyphen)
+	s = strings.ReplaceAll(s, softHyphen, "")
+	if broken && s != "" {
+		s += softHyphen
+	}
+	return s
+}
@@ -0,0 +1,417 @@
+package yapp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// OCREngine recognises text on pages that carry no text layer. The lexer
+// calls it once per such page and feeds the words through the normal
+// line and word grouping.
+type OCREngine interface {
+	Recognize(page OCRPage) ([]OCRWord, error)
+}
+
+// OCRFunc adapts a plain function to OCREngine.
+type OCRFunc func(page OCRPage) ([]OCRWord, error)
+
+func (f OCRFunc) Recognize(page OCRPage) ([]OCRWord, error) {
+	return f(page)
+}
+
+// OCRPage describes a page handed to an OCREngine.
+type OCRPage struct {
+	Path     string  // PDF being parsed
+	Password string  // Options.Password, for engines that open the PDF; keep it off command lines
+	Number   int     // 1-based page number
+	Width    float64 // page width in points, as displayed
+	Height   float64 // page height in points, as displayed
+	// Rotation is the page's /Rotate. Width, Height and Image are already
+	// turned by it, as renderers such as pdftoppm turn the page.
+	Rotation int
+	// Image is the page's scanned image when it could be extracted from the
+	// PDF directly, placed where the page draws it and covering the whole
+	// page; nil means the engine has to render the page itself.
+	Image image.Image
+}
+
+// OCRWord is a recognised word in page space (see Position): points,
+// origin at the bottom-left corner of the page as displayed. X, Y is the
+// bottom-left corner of the word's box, which stands in for the baseline
+// since engines do not report one.
+type OCRWord struct {
+	Text       string
+	X          float64
+	Y          float64
+	Width      float64
+	Height     float64
+	Confidence float64 // 0-100, as reported by the engine
+}
+
+// TesseractOCR runs the tesseract CLI. Pages without an extractable image
+// are rasterised with pdftoppm (poppler-utils) first. pdftoppm only takes
+// passwords as arguments, which any local user can read in the process
+// list, so pages of encrypted documents are first decrypted with qpdf,
+// which reads the password from a file only this process can read.
+type TesseractOCR struct {
+	Command   string // tesseract binary; default "tesseract"
+	Renderer  string // pdftoppm binary; default "pdftoppm"
+	Decrypter string // qpdf binary; default "qpdf"
+	Languages string // tesseract -l value; default "eng"
+	DPI       int    // render resolution; default 300
+}
+
+func (e TesseractOCR) Recognize(page OCRPage) ([]OCRWord, error) {
+	dir, err := os.MkdirTemp("", "yapp-ocr-")
+	if err != nil {
+		return nil, fmt.Errorf("temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgPath := filepath.Join(dir, "page.png")
+	if page.Image != nil {
+		if err := writePNG(imgPath, page.Image); err != nil {
+			return nil, err
+		}
+	} else {
+		src, num := page.Path, strconv.Itoa(page.Number)
+		if page.Password != "" {
+			if src, err = e.decryptPage(dir, page); err != nil {
+				return nil, err
+			}
+			num = "1"
+		}
+		prefix := strings.TrimSuffix(imgPath, ".png")
+		args := []string{"-f", num, "-l", num, "-r", strconv.Itoa(e.dpi()), "-png", "-singlefile", src, prefix}
+		cmd := exec.Command(orDefault(e.Renderer, "pdftoppm"), args...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("render page: %w: %s", err, bytes.TrimSpace(out))
+		}
+	}
+
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return nil, fmt.Errorf("open page image: %w", err)
+	}
+	cfg, err := png.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decode page image: %w", err)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, fmt.Errorf("empty page image")
+	}
+
+	cmd := exec.Command(orDefault(e.Command, "tesseract"), imgPath, "stdout",
+		"-l", orDefault(e.Languages, "eng"), "--dpi", strconv.Itoa(e.dpi()), "tsv")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("tesseract: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	scaleX := page.Width / float64(cfg.Width)
+	scaleY := page.Height / float64(cfg.Height)
+	return parseTesseractTSV(bytes.NewReader(out), scaleX, scaleY, page.Height)
+}
+
+// decryptPage writes the page, decrypted, to a one-page PDF in dir and
+// returns its path. The password goes to qpdf in a file in dir, which
+// MkdirTemp made readable by this user only.
+func (e TesseractOCR) decryptPage(dir string, page OCRPage) (string, error) {
+	pwPath := filepath.Join(dir, "password")
+	if err := os.WriteFile(pwPath, []byte(page.Password), 0o600); err != nil {
+		return "", fmt.Errorf("write password file: %w", err)
+	}
+	out := filepath.Join(dir, "page.pdf")
+	cmd := exec.Command(orDefault(e.Decrypter, "qpdf"), "--password-file="+pwPath, "--decrypt",
+		page.Path, "--pages", ".", strconv.Itoa(page.Number), "--", out)
+	msg, err := cmd.CombinedOutput()
+	// qpdf exits with 3 when it succeeded with warnings.
+	var exit *exec.ExitError
+	if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == 3) {
+		return "", fmt.Errorf("decrypt page: %w: %s", err, bytes.TrimSpace(msg))
+	}
+	return out, nil
+}
+
+func (e TesseractOCR) dpi() int {
+	if e.DPI > 0 {
+		return e.DPI
+	}
+	return 300
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("write page image: %w", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("encode page image: %w", err)
+	}
+	return f.Close()
+}
+
+// parseTesseractTSV turns tesseract's TSV output (pixels, origin top-left)
+// into words in page space.
+func parseTesseractTSV(r io.Reader, scaleX, scaleY, pageHeight float64) ([]OCRWord, error) {
+	var words []OCRWord
+	sc := bufio.NewScanner(r)
+	header := true
+	for sc.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		// level page block par line word left top width height conf text
+		cols := strings.SplitN(sc.Text(), "\t", 12)
+		if len(cols) < 12 || cols[0] != "5" {
+			continue
+		}
+		text := strings.TrimSpace(cols[11])
+		conf, _ := strconv.ParseFloat(cols[10], 64)
+		if text == "" || conf < 0 {
+			continue
+		}
+		var box [4]float64
+		for i := range box {
+			v, err := strconv.ParseFloat(cols[6+i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("tesseract tsv: bad coordinate %q", cols[6+i])
+			}
+			box[i] = v
+		}
+		left, top, w, h := box[0], box[1], box[2], box[3]
+		words = append(words, OCRWord{
+			Text:       text,
+			X:          left * scaleX,
+			Y:          pageHeight - (top+h)*scaleY,
+			Width:      w * scaleX,
+			Height:     h * scaleY,
+			Confidence: conf,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("tesseract tsv: %w", err)
+	}
+	return words, nil
+}
+
+// ocrGlyphs asks the engine for a page's words and turns them into glyphs,
+// each followed by a space so buildWords keeps the engine's word breaks.
+// The glyphs go through rotatePage like the page's own text. req names the
+// page; ocrGlyphs fills in its size and image, taken from the images the
+// page paints.
+func ocrGlyphs(engine OCREngine, req OCRPage, page pdf.Page, images []placedImage, boxes pageBoxes) ([]glyph, error) {
+	box, rotation := boxes.media, boxes.rotation
+	width, height := box.x1-box.x0, box.y1-box.y0
+	if rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	img := scanImage(page, images, box)
+	if img != nil {
+		img = rotateImage(img, rotation)
+	}
+	req.Width, req.Height, req.Rotation, req.Image = width, height, rotation, img
+	words, err := engine.Recognize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Words are placed in user space, turned as far as the page is, so
+	// that rotatePage sets them upright again.
+	glyphs := make([]glyph, 0, len(words)*2)
+	for _, w := range words {
+		text := strings.TrimSpace(w.Text)
+		if text == "" {
+			continue
+		}
+		x, y := unrotatePoint(w.X, w.Y, rotation, box)
+		endX, endY := unrotatePoint(w.X+w.Width, w.Y, rotation, box)
+		wordBox := unrotateBox(bbox{w.X, w.Y, w.X + w.Width, w.Y + w.Height}, rotation, box)
+		spaceBox := unrotateBox(bbox{w.X + w.Width, w.Y, w.X + w.Width, w.Y + w.Height}, rotation, box)
+		glyphs = append(glyphs,
+			glyph{Text: pdf.Text{Font: "OCR", FontSize: w.Height, X: x, Y: y, W: w.Width, S: text}, ocr: true,
+				box: wordBox, baseline: y, rotation: float64(rotation)},
+			glyph{Text: pdf.Text{Font: "OCR", FontSize: w.Height, X: endX, Y: endY, S: " "}, ocr: true,
+				box: spaceBox, baseline: endY, rotation: float64(rotation)},
+		)
+	}
+	rotatePage(glyphs, rotation, box)
+	return glyphs, nil
+}
+
+// rotateImage turns img clockwise by rotation, a multiple of 90 degrees,
+// the way a page with that /Rotate is displayed.
+func rotateImage(img image.Image, rotation int) image.Image {
+	if rotation == 0 {
+		return img
+	}
+	return rotatedImage{img, rotation}
+}
+
+type rotatedImage struct {
+	src      image.Image
+	rotation int
+}
+
+func (r rotatedImage) ColorModel() color.Model { return r.src.ColorModel() }
+
+func (r rotatedImage) Bounds() image.Rectangle {
+	size := r.src.Bounds().Size()
+	if r.rotation == 90 || r.rotation == 270 {
+		size.X, size.Y = size.Y, size.X
+	}
+	return image.Rectangle{Max: size}
+}
+
+func (r rotatedImage) At(x, y int) color.Color {
+	b := r.src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	switch r.rotation {
+	case 90:
+		x, y = y, h-1-x
+	case 180:
+		x, y = w-1-x, h-1-y
+	case 270:
+		x, y = w-1-y, x
+	}
+	return r.src.At(b.Min.X+x, b.Min.Y+y)
+}
+
+// scanImage returns the image the page paints over the largest area,
+// placed on the page's MediaBox media, when it is stored in a form we can decode
+// without an image codec: 8-bit gray or RGB samples, raw or
+// Flate-compressed. Scanned pages are usually exactly that or JPEG;
+// anything else returns nil and leaves rendering to the engine.
+func scanImage(page pdf.Page, images []placedImage, media bbox) (img image.Image) {
+	defer func() {
+		if recover() != nil {
+			img = nil
+		}
+	}()
+
+	var placed placedImage
+	bestArea := 0.0
+	for _, p := range images {
+		if area := math.Abs(p.ctm[0][0]*p.ctm[1][1] - p.ctm[0][1]*p.ctm[1][0]); area > bestArea {
+			placed, bestArea = p, area
+		}
+	}
+	if bestArea == 0 {
+		return nil
+	}
+	if img = decodeImage(page.Resources().Key("XObject").Key(placed.name)); img == nil {
+		return nil
+	}
+	return placeImage(img, placed.ctm, media)
+}
+
+// decodeImage decodes an image XObject scanImage can handle, or returns
+// nil.
+func decodeImage(x pdf.Value) image.Image {
+	filter := x.Key("Filter")
+	if filter.Kind() == pdf.Array && filter.Len() == 1 {
+		filter = filter.Index(0)
+	}
+	if filter.Kind() != pdf.Null && filter.Name() != "FlateDecode" {
+		return nil
+	}
+	if x.Key("BitsPerComponent").Int64() != 8 {
+		return nil
+	}
+
+	w, h := int(x.Key("Width").Int64()), int(x.Key("Height").Int64())
+	rd := x.Reader()
+	defer rd.Close()
+	switch x.Key("ColorSpace").Name() {
+	case "DeviceGray":
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		if _, err := io.ReadFull(rd, g.Pix); err != nil {
+			return nil
+		}
+		return g
+	case "DeviceRGB":
+		samples := make([]byte, w*h*3)
+		if _, err := io.ReadFull(rd, samples); err != nil {
+			return nil
+		}
+		c := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			copy(c.Pix[i*4:], samples[i*3:i*3+3])
+			c.Pix[i*4+3] = 0xff
+		}
+		return c
+	}
+	return nil
+}
+
+// maxScanScale caps the resolution, in pixels per point, of the page image
+// placeImage makes: 600 dpi.
+const maxScanScale = 600.0 / 72
+
+// placeImage draws img where ctm puts it on a page of size media, at the
+// image's own resolution, on white.
+func placeImage(img image.Image, ctm matrix, media bbox) image.Image {
+	inv, ok := ctm.inverse()
+	if !ok {
+		return nil
+	}
+	b := img.Bounds()
+	scale := math.Max(float64(b.Dx())/math.Hypot(ctm[0][0], ctm[0][1]), float64(b.Dy())/math.Hypot(ctm[1][0], ctm[1][1]))
+	scale = math.Min(scale, maxScanScale)
+	w := max(1, int(math.Round((media.x1-media.x0)*scale)))
+	h := max(1, int(math.Round((media.y1-media.y0)*scale)))
+	return placedPage{src: img, inv: inv, media: media, scale: scale, size: image.Pt(w, h)}
+}
+
+// placedPage is a page-sized image showing src where the page paints it.
+type placedPage struct {
+	src   image.Image
+	inv   matrix // page to image unit square
+	media bbox
+	scale float64 // pixels per point
+	size  image.Point
+}
+
+func (p placedPage) ColorModel() color.Model { return p.src.ColorModel() }
+
+func (p placedPage) Bounds() image.Rectangle { return image.Rectangle{Max: p.size} }
+
+func (p placedPage) At(x, y int) color.Color {
+	// Pixel centres to user space, then to the unit square the image fills,
+	// whose top edge is the image's first row.
+	ux := p.media.x0 + (float64(x)+0.5)/p.scale
+	uy := p.media.y0 + (float64(p.size.Y-y)-0.5)/p.scale
+	u, v := p.inv.apply(ux, uy)
+	if u < 0 || u >= 1 || v < 0 || v >= 1 {
+		return color.White
+	}
+	b := p.src.Bounds()
+	row := min(int((1-v)*float64(b.Dy())), b.Dy()-1)
+	return p.src.At(b.Min.X+int(u*float64(b.Dx())), b.Min.Y+row)
+}
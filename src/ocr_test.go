@@ -0,0 +1,168 @@
+package yapp
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOCREngineFillsEmptyPages(t *testing.T) {
+	path := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (Born digital) Tj ET", "")
+
+	var calls []OCRPage
+	engine := OCRFunc(func(page OCRPage) ([]OCRWord, error) {
+		calls = append(calls, page)
+		return []OCRWord{
+			{Text: "Scanned", X: 72, Y: 700, Width: 50, Height: 12, Confidence: 93},
+			{Text: "page", X: 126, Y: 700, Width: 28, Height: 12, Confidence: 91},
+		}, nil
+	})
+
+	res, err := ParseFileWithOptions(path, Options{OCR: engine})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(calls) != 1 || calls[0].Number != 2 || calls[0].Width != 612 || calls[0].Height != 792 {
+		t.Fatalf("OCR calls = %+v, want one call for page 2 at 612x792", calls)
+	}
+	if len(res.AST.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(res.AST.Pages))
+	}
+	spans := res.AST.Pages[1].Blocks[0].Lines[0].Spans
+	if len(spans) != 2 || spans[0].Text != "Scanned" || spans[1].Text != "page" || !spans[0].Pos.OCR {
+		t.Fatalf("page 2 spans = %+v, want OCR words", spans)
+	}
+	if !strings.Contains(res.Markdown, "Scanned page") {
+		t.Fatalf("markdown missing OCR text:\n%s", res.Markdown)
+	}
+}
+
+func TestOCRRotatedPage(t *testing.T) {
+	path := writeTestPDF(t, pageAttrs("/Rotate 90", "q 612 0 0 792 0 0 cm /Im1 Do Q"))
+
+	var got OCRPage
+	engine := OCRFunc(func(page OCRPage) ([]OCRWord, error) {
+		got = page
+		return []OCRWord{
+			{Text: "Landscape", X: 72, Y: 500, Width: 60, Height: 12, Confidence: 90},
+			{Text: "scan", X: 136, Y: 500, Width: 28, Height: 12, Confidence: 90},
+		}, nil
+	})
+	res, err := ParseFileWithOptions(path, Options{OCR: engine})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got.Width != 792 || got.Height != 612 || got.Rotation != 90 {
+		t.Fatalf("OCR page = %+v, want 792x612 turned by 90", got)
+	}
+	span := res.AST.Pages[0].Blocks[0].Lines[0].Spans[0]
+	if span.Text != "Landscape" || span.Pos.X != 72 || span.Pos.Y != 500 || span.Pos.Rotation != 0 {
+		t.Fatalf("first span = %+v, want Landscape upright at 72,500", span)
+	}
+	if !strings.Contains(res.Markdown, "Landscape scan") {
+		t.Fatalf("markdown = %q", res.Markdown)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	img.Pix[0] = 255
+	turned := rotateImage(img, 90)
+	if b := turned.Bounds(); b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("turned bounds = %v, want 1x2", b)
+	}
+	if r, _, _, _ := turned.At(0, 0).RGBA(); r != 0xffff {
+		t.Errorf("top pixel of the turned image is not the left pixel of the original")
+	}
+}
+
+func TestOCRPlacedScan(t *testing.T) {
+	// The scan fills only the bottom-right quarter of the page.
+	path := writeTestPDF(t, "q 306 0 0 396 306 0 cm /Im1 Do Q")
+	var got OCRPage
+	engine := OCRFunc(func(page OCRPage) ([]OCRWord, error) {
+		got = page
+		return nil, nil
+	})
+	if _, err := ParseFileWithOptions(path, Options{OCR: engine}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got.Image == nil {
+		t.Fatal("no page image")
+	}
+	b := got.Image.Bounds()
+	if float64(b.Dy())/float64(b.Dx()) < 1.2 {
+		t.Fatalf("page image bounds = %v, want the page's portrait shape", b)
+	}
+	if y, _, _, _ := got.Image.At(0, 0).RGBA(); y != 0xffff {
+		t.Errorf("top-left of the page = %x, want white", y)
+	}
+	if y, _, _, _ := got.Image.At(b.Dx()-1, b.Dy()-1).RGBA(); y != 0x8080 {
+		t.Errorf("bottom-right of the page = %x, want the scan's gray", y)
+	}
+}
+
+func TestOCREncryptedPage(t *testing.T) {
+	path := writeEncryptedTestPDF(t, "s3cret", "q 612 0 0 792 0 0 cm /Im1 Do Q")
+	var got OCRPage
+	engine := OCRFunc(func(page OCRPage) ([]OCRWord, error) {
+		got = page
+		return nil, nil
+	})
+	if _, err := ParseFileWithOptions(path, Options{OCR: engine, Password: "s3cret"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got.Password != "s3cret" {
+		t.Fatalf("OCR page password = %q, want the document's", got.Password)
+	}
+
+	// qpdf gets the password in a file and writes the decrypted page, which
+	// pdftoppm renders; the fakes record what they were given.
+	dir := t.TempDir()
+	decrypter := filepath.Join(dir, "qpdf")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "qpdf-args") + "\n" +
+		"cat \"${1#--password-file=}\" > " + filepath.Join(dir, "password") + "\n" +
+		"for a; do out=$a; done\n: > \"$out\"\n"
+	if err := os.WriteFile(decrypter, []byte(script), 0o755); err != nil {
+		t.Fatalf("write decrypter: %v", err)
+	}
+	renderer := filepath.Join(dir, "pdftoppm")
+	script = "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "pdftoppm-args") + "\nexit 1\n"
+	if err := os.WriteFile(renderer, []byte(script), 0o755); err != nil {
+		t.Fatalf("write renderer: %v", err)
+	}
+	_, err := TesseractOCR{Renderer: renderer, Decrypter: decrypter}.Recognize(OCRPage{Path: path, Password: "s3cret", Number: 1})
+	if err == nil {
+		t.Fatal("failing renderer did not fail")
+	}
+	password, _ := os.ReadFile(filepath.Join(dir, "password"))
+	if string(password) != "s3cret" {
+		t.Errorf("qpdf password file = %q, want the password", password)
+	}
+	for _, name := range []string{"qpdf-args", "pdftoppm-args"} {
+		args, _ := os.ReadFile(filepath.Join(dir, name))
+		if len(args) == 0 || strings.Contains(string(args), "s3cret") {
+			t.Errorf("%s = %q, want arguments without the password", name, args)
+		}
+	}
+}
+
+func TestParseTesseractTSV(t *testing.T) {
+	tsv := "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+		"1\t1\t0\t0\t0\t0\t0\t0\t2550\t3300\t-1\t\n" +
+		"5\t1\t1\t1\t1\t1\t300\t300\t250\t50\t96.5\tHello\n" +
+		"5\t1\t1\t1\t1\t2\t600\t300\t300\t50\t95\tworld\n" +
+		"5\t1\t1\t1\t1\t3\t950\t300\t10\t50\t-1\t \n"
+
+	words, err := parseTesseractTSV(strings.NewReader(tsv), 72.0/300, 72.0/300, 792)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(words) != 2 {
+		t.Fatalf("got %d words, want 2: %+v", len(words), words)
+	}
+	w := words[0]
+	if w.Text != "Hello" || w.X != 72 || w.Y != 792-84 || w.Width != 60 || w.Height != 12 {
+		t.Fatalf("first word = %+v", w)
+	}
+}
@@ -0,0 +1,175 @@
+package yapp
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// pageRotation returns the page's /Rotate as 0, 90, 180 or 270: how far
+// the page is turned clockwise when displayed.
+func pageRotation(page pdf.Page) int {
+	r := int(inherited(page, "Rotate").Int64()) % 360
+	if r < 0 {
+		r += 360
+	}
+	return r / 90 * 90
+}
+
+// rotatePage turns glyph coordinates from user space into page space: the
+// orientation the page is displayed in, with the origin at the bottom-left
+// corner of the displayed MediaBox, box. Glyph rotations become relative
+// to the displayed page.
+func rotatePage(glyphs []glyph, rotation int, box bbox) {
+	if rotation == 0 && box.x0 == 0 && box.y0 == 0 {
+		return
+	}
+	to := func(x, y float64) (float64, float64) { return rotatePoint(x, y, rotation, box) }
+	for i := range glyphs {
+		g := &glyphs[i]
+		_, g.baseline = to(g.X, g.baseline)
+		g.X, g.Y = to(g.X, g.Y)
+		g.box = rotateBox(g.box, rotation, box)
+		g.rotation = normalizeAngle(g.rotation - float64(rotation))
+	}
+}
+
+// rotatePoint takes a point from user space to the page space of a page
+// with MediaBox box and the given /Rotate.
+func rotatePoint(x, y float64, rotation int, box bbox) (float64, float64) {
+	switch rotation {
+	case 90:
+		return y - box.y0, box.x1 - x
+	case 180:
+		return box.x1 - x, box.y1 - y
+	case 270:
+		return box.y1 - y, x - box.x0
+	}
+	return x - box.x0, y - box.y0
+}
+
+// rotateBox is rotatePoint for a rectangle.
+func rotateBox(b bbox, rotation int, box bbox) bbox {
+	x0, y0 := rotatePoint(b.x0, b.y0, rotation, box)
+	x1, y1 := rotatePoint(b.x1, b.y1, rotation, box)
+	return bbox{x0, y0, x0, y0}.extend(x1, y1)
+}
+
+// unrotatePoint undoes rotatePoint, taking a point from page space back to
+// user space.
+func unrotatePoint(x, y float64, rotation int, box bbox) (float64, float64) {
+	switch rotation {
+	case 90:
+		return box.x1 - y, x + box.y0
+	case 180:
+		return box.x1 - x, box.y1 - y
+	case 270:
+		return y + box.x0, box.y1 - x
+	}
+	return x + box.x0, y + box.y0
+}
+
+// unrotateBox is unrotatePoint for a rectangle.
+func unrotateBox(b bbox, rotation int, box bbox) bbox {
+	x0, y0 := unrotatePoint(b.x0, b.y0, rotation, box)
+	x1, y1 := unrotatePoint(b.x1, b.y1, rotation, box)
+	return bbox{x0, y0, x0, y0}.extend(x1, y1)
+}
+
+// normalizeAngle maps degrees into (-180, 180].
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a <= -180 {
+		a += 360
+	} else if a > 180 {
+		a -= 360
+	}
+	return a
+}
+
+// direction is the way a glyph's text runs, in whole degrees
+// counter-clockwise from left-to-right. Vertical writing runs downwards.
+func (g glyph) direction() int {
+	return textDirection(g.rotation, g.vertical)
+}
+
+// direction is glyph.direction for the text at p.
+func (p Position) direction() int {
+	return textDirection(p.Rotation, p.Vertical)
+}
+
+func textDirection(rotation float64, vertical bool) int {
+	if vertical {
+		rotation -= 90
+	}
+	d := int(math.Round(normalizeAngle(rotation)))
+	if d == -180 {
+		d = 180
+	}
+	return d
+}
+
+// directionRun is the glyphs of a page that run in one direction.
+type directionRun struct {
+	angle  int
+	glyphs []glyph
+}
+
+// splitDirections groups glyphs by direction. Horizontal text comes first,
+// then the other directions by how much text they carry.
+func splitDirections(glyphs []glyph) []directionRun {
+	index := make(map[int]int)
+	var runs []directionRun
+	for _, g := range glyphs {
+		d := g.direction()
+		i, ok := index[d]
+		if !ok {
+			i = len(runs)
+			index[d] = i
+			runs = append(runs, directionRun{angle: d})
+		}
+		runs[i].glyphs = append(runs[i].glyphs, g)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		if (runs[i].angle == 0) != (runs[j].angle == 0) {
+			return runs[i].angle == 0
+		}
+		return len(runs[i].glyphs) > len(runs[j].glyphs)
+	})
+	return runs
+}
+
+// turn rotates a point counter-clockwise by angle degrees about the origin.
+func turn(x, y float64, angle int) (float64, float64) {
+	switch angle {
+	case 0:
+		return x, y
+	case 90:
+		return -y, x
+	case 180, -180:
+		return -x, -y
+	case -90, 270:
+		return y, -x
+	}
+	s, c := math.Sincos(float64(angle) * math.Pi / 180)
+	return x*c - y*s, x*s + y*c
+}
+
+// toReadingFrame rotates glyphs running in direction angle so that they run
+// left to right, which is what groupLines and buildWords expect. Glyph boxes
+// stay in page space.
+func toReadingFrame(glyphs []glyph, angle int) {
+	for i := range glyphs {
+		g := &glyphs[i]
+		g.X, g.Y = turn(g.X, g.Y, -angle)
+	}
+}
+
+// fromReadingFrame undoes toReadingFrame on token positions.
+func fromReadingFrame(tokens []Token, angle int) {
+	for i := range tokens {
+		p := &tokens[i].Pos
+		p.X, p.Y = turn(p.X, p.Y, angle)
+	}
+}
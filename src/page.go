@@ -0,0 +1,113 @@
+package yapp
+
+import (
+	"github.com/ledongthuc/pdf"
+)
+
+// letterBox is the page size assumed when a page has no usable MediaBox.
+var letterBox = bbox{0, 0, 612, 792}
+
+// inherited looks up a page attribute, walking up the page tree for the
+// inheritable ones (Resources, MediaBox, CropBox, Rotate).
+func inherited(page pdf.Page, key string) pdf.Value {
+	for v := page.V; !v.IsNull(); v = v.Key("Parent") {
+		if r := v.Key(key); !r.IsNull() {
+			return r
+		}
+	}
+	return pdf.Value{}
+}
+
+// readBox decodes a PDF rectangle array, normalising the corner order.
+func readBox(v pdf.Value) (bbox, bool) {
+	if v.Kind() != pdf.Array || v.Len() != 4 {
+		return bbox{}, false
+	}
+	x0, y0 := v.Index(0).Float64(), v.Index(1).Float64()
+	x1, y1 := v.Index(2).Float64(), v.Index(3).Float64()
+	b := bbox{x0, y0, x0, y0}.extend(x1, y1)
+	if b.x1-b.x0 <= 0 || b.y1-b.y0 <= 0 {
+		return bbox{}, false
+	}
+	return b, true
+}
+
+func mediaBox(page pdf.Page) bbox {
+	if b, ok := readBox(inherited(page, "MediaBox")); ok {
+		return b
+	}
+	return letterBox
+}
+
+// cropBox is the visible part of the page: its CropBox clipped to the
+// MediaBox, or the MediaBox when it has none.
+func cropBox(page pdf.Page) bbox {
+	media := mediaBox(page)
+	if b, ok := readBox(inherited(page, "CropBox")); ok {
+		if c := b.intersect(media); c.x1 > c.x0 && c.y1 > c.y0 {
+			return c
+		}
+	}
+	return media
+}
+
+// pageBoxes are a page's MediaBox and visible crop in user space, and its
+// /Rotate.
+type pageBoxes struct {
+	media, crop bbox
+	rotation    int
+}
+
+// readPageBoxes reads a page's boxes and rotation. When the pdf package
+// cannot read them, the page is taken to be its MediaBox, unrotated, or US
+// Letter when that fails too.
+func readPageBoxes(page pdf.Page) (b pageBoxes) {
+	b = pageBoxes{media: letterBox, crop: letterBox}
+	defer func() {
+		if recover() != nil {
+			b.crop, b.rotation = b.media, 0
+		}
+	}()
+	b.media = mediaBox(page)
+	b.crop = cropBox(page)
+	b.rotation = pageRotation(page)
+	return b
+}
+
+// geometry describes the page in page space, labelled label.
+func (b pageBoxes) geometry(label string) PageGeometry {
+	media := rotateBox(b.media, b.rotation, b.media)
+	crop := rotateBox(b.crop, b.rotation, b.media)
+	return PageGeometry{
+		Label:    label,
+		Width:    crop.x1 - crop.x0,
+		Height:   crop.y1 - crop.y0,
+		MediaBox: Box{media.x0, media.y0, media.x1, media.y1},
+		CropBox:  Box{crop.x0, crop.y0, crop.x1, crop.y1},
+		Rotation: b.rotation,
+	}
+}
+
+// markOffPage flags the glyphs, still in user space, whose middle lies
+// outside the visible page crop and returns how many it flagged.
+func markOffPage(glyphs []glyph, crop bbox) int {
+	n := 0
+	for i := range glyphs {
+		g := &glyphs[i]
+		if g.offPage = !crop.contains(g.X+g.W/2, g.Y, 1); g.offPage {
+			n++
+		}
+	}
+	return n
+}
+
+// dropOffPage returns the glyphs markOffPage did not flag.
+func dropOffPage(glyphs []glyph) []glyph {
+	kept := make([]glyph, 0, len(glyphs))
+	for _, g := range glyphs {
+		if !g.offPage {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
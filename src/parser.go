@@ -15,6 +15,7 @@ func (p *Parser) Parse() DocumentNode {
 	var currentBlock *BlockNode
 	var currentLine *LineNode
 	newlineCount := 0
+	ltr, rtl := 0, 0
 
 	flushLine := func() {
 		if currentLine == nil || len(currentLine.Spans) == 0 {
@@ -35,6 +36,15 @@ func (p *Parser) Parse() DocumentNode {
 		if len(currentBlock.Lines) == 0 || currentPage == nil {
 			return
 		}
+		blockLTR, blockRTL := 0, 0
+		for _, line := range currentBlock.Lines {
+			for _, span := range line.Spans {
+				l, r := directionOf(span.Text)
+				blockLTR, blockRTL = blockLTR+l, blockRTL+r
+			}
+		}
+		currentBlock.Direction = majorityDirection(blockLTR, blockRTL)
+		ltr, rtl = ltr+blockLTR, rtl+blockRTL
 		currentPage.Blocks = append(currentPage.Blocks, *currentBlock)
 		currentBlock = &BlockNode{}
 	}
@@ -94,5 +104,6 @@ func (p *Parser) Parse() DocumentNode {
 		}
 	}
 
+	doc.Direction = majorityDirection(ltr, rtl)
 	return doc
 }
@@ -17,65 +17,251 @@ type lineStyle struct {
 	y        float64
 }
 
-func renderMarkdown(doc DocumentNode) string {
+// elementKind is the Markdown construct an element renders as.
+type elementKind int
+
+const (
+	elemHeading elementKind = iota
+	elemParagraph
+	elemList
+	elemTable
+	elemAside
+	elemFootnote
+	elemCode
+)
+
+// element is one structural unit of the rendered document: what a line or
+// run of lines was recognised as, plus the lines it came from.
+type element struct {
+	kind  elementKind
+	page  int
+	level int        // heading level
+	label string     // footnote label
+	parts []string   // paragraph, list item or code lines, or the heading/aside text
+	rows  [][]string // table cells, header row first
+	// lines are the source lines; except for tables, lines[i] produced parts[i].
+	lines []lineStyle
+}
+
+// markdown renders the element without the blank line that follows it.
+func (e element) markdown(flavor MarkdownFlavor) string {
+	var b strings.Builder
+	e.write(&b, nil, flavor)
+	return b.String()
+}
+
+// write appends the element's Markdown to b. When sm is not nil, it also
+// records where the text of each source line ended up.
+func (e element) write(b *strings.Builder, sm *[]SourceSpan, flavor MarkdownFlavor) {
+	mark := func(text string, lines ...lineStyle) {
+		text = strings.ReplaceAll(text, softHyphen, "")
+		start := b.Len()
+		if e.kind == elemCode {
+			b.WriteString(text)
+		} else {
+			b.WriteString(applyFlavor(text, flavor))
+		}
+		if sm == nil {
+			return
+		}
+		for _, box := range lineBoxes(lines) {
+			*sm = append(*sm, SourceSpan{
+				Start: start, End: b.Len(), Page: box.Page,
+				X: box.X0, Y: box.Y0, Width: box.X1 - box.X0, Height: box.Y1 - box.Y0,
+			})
+		}
+	}
+
+	switch e.kind {
+	case elemHeading:
+		b.WriteString(strings.Repeat("#", e.level) + " ")
+		mark(e.parts[0], e.lines[0])
+	case elemList:
+		for i, item := range e.parts {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("- ")
+			mark(item, e.lines[i])
+		}
+	case elemTable:
+		mark(tableMarkdown(e.rows), e.lines...)
+	case elemAside:
+		b.WriteString("_")
+		mark(e.parts[0], e.lines[0])
+		b.WriteString("_")
+	case elemCode:
+		fence := codeFence(e.parts)
+		b.WriteString(fence + "\n")
+		for i, line := range e.parts {
+			mark(line, e.lines[i])
+			b.WriteString("\n")
+		}
+		b.WriteString(fence)
+	case elemFootnote:
+		b.WriteString("[^" + e.label + "]: ")
+		for i, part := range e.parts {
+			if i > 0 && !joinsTight(e.parts[i-1], part) {
+				b.WriteString(" ")
+			}
+			mark(part, e.lines[i])
+		}
+	default:
+		for i, part := range e.parts {
+			if i > 0 && !joinsTight(e.parts[i-1], part) {
+				b.WriteString(" ")
+			}
+			mark(part, e.lines[i])
+		}
+	}
+}
+
+// renderedPage is a page's elements in reading order.
+type renderedPage struct {
+	number    int
+	label     string
+	elements  []element
+	footnotes []element
+}
+
+// SourceSpan maps a byte range of the rendered Markdown back to the box on
+// the page its text came from, in page space (see Position).
+type SourceSpan struct {
+	Start  int     `json:"start"` // byte offset of the first byte
+	End    int     `json:"end"`   // byte offset just past the last byte
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// renderMarkdown renders the document and maps every line of source text
+// to its place in the output. Table boxes cover the whole table.
+func renderMarkdown(doc DocumentNode, flavor MarkdownFlavor) (string, []SourceSpan) {
 	var b strings.Builder
+	sm := []SourceSpan{}
+	pages := structureDocument(doc)
+
+	for pageIdx, page := range pages {
+		if len(pages) > 1 {
+			b.WriteString("## Page ")
+			if page.label != "" {
+				b.WriteString(page.label)
+			} else {
+				b.WriteString(fmtInt(page.number))
+			}
+			b.WriteString("\n\n")
+		}
+
+		for _, el := range page.elements {
+			el.write(&b, &sm, flavor)
+			b.WriteString("\n\n")
+		}
+
+		if len(pages) > 1 && pageIdx != len(pages)-1 {
+			b.WriteString("\n")
+		}
+	}
+
+	// Footnote definitions are collected at the end of the document.
+	for _, page := range pages {
+		for _, note := range page.footnotes {
+			note.write(&b, &sm, flavor)
+			b.WriteString("\n\n")
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n") + "\n", sm
+}
+
+// structureDocument runs the layout heuristics over every page and returns
+// the recognised elements.
+func structureDocument(doc DocumentNode) []renderedPage {
 	bodySize := medianFontSize(doc)
 	if bodySize == 0 {
 		bodySize = 12
 	}
 
 	var lastTableHeader []string
+	footnoteLabels := make(map[string]bool)
+	tagged := newStructIndex(doc.Structure)
+	prose := typewritten(doc)
+	pages := make([]renderedPage, 0, len(doc.Pages))
 
 	for pageIdx, page := range doc.Pages {
-		if len(doc.Pages) > 1 {
-			b.WriteString("## Page ")
-			b.WriteString(strings.TrimSpace(fmtInt(page.Number)))
-			b.WriteString("\n\n")
-		}
-
 		// Flatten blocks into line strings while preserving basic style hints.
 		var lines []lineStyle
 		for _, block := range page.Blocks {
 			for _, line := range block.Lines {
-				text := strings.TrimSpace(joinSpans(line.Spans))
-				if text == "" {
-					continue
+				spans := line.Spans
+				if prose {
+					spans = proseSpans(spans)
+				}
+				if ls := newLineStyle(spans); ls.text != "" {
+					lines = append(lines, ls)
 				}
-				lines = append(lines, lineStyle{
-					text:     normalizeSpaces(text),
-					fontSize: maxSpanSize(line.Spans),
-					spans:    line.Spans,
-					xs:       spanStarts(line.Spans),
-					italic:   spansAreItalic(line.Spans),
-					y:        line.Spans[0].Pos.Y,
-				})
 			}
 		}
 
-		// Render with generic structure detection.
+		if tagged != nil {
+			lines = splitByBlock(lines, tagged)
+		}
+		lines, notes := splitFootnotes(lines, bodySize)
+
+		// Generic structure detection.
+		var elements []element
+		emit := func(kind elementKind, level int, parts []string, lines []lineStyle) {
+			elements = append(elements, element{kind: kind, page: page.Number, level: level, parts: parts, lines: lines})
+		}
 		firstHeading := true
-		var para []string
-		var listItems []string
+		var para, listItems []string
+		var paraLines, listLines []lineStyle
 		flushPara := func() {
 			if len(para) == 0 {
 				return
 			}
-			b.WriteString(strings.Join(para, " ") + "\n\n")
-			para = nil
+			emit(elemParagraph, 0, para, paraLines)
+			para, paraLines = nil, nil
 		}
 		flushList := func() {
 			if len(listItems) == 0 {
 				return
 			}
-			for _, item := range listItems {
-				b.WriteString("- " + item + "\n")
-			}
-			b.WriteString("\n")
-			listItems = nil
+			emit(elemList, 0, listItems, listLines)
+			listItems, listLines = nil, nil
 		}
 
 		for i := 0; i < len(lines); i++ {
 			line := lines[i]
+
+			// Text running in another direction never continues a paragraph.
+			if i > 0 && line.spans[0].Pos.direction() != lines[i-1].spans[0].Pos.direction() {
+				flushList()
+				flushPara()
+			}
+
+			if el, used := taggedElements(lines[i:], tagged, page.Number); used > 0 {
+				flushList()
+				flushPara()
+				elements = append(elements, el)
+				i += used - 1
+				continue
+			}
+
+			if isCodeLine(line) {
+				flushList()
+				flushPara()
+				j := i + 1
+				for j < len(lines) && isCodeLine(lines[j]) {
+					j++
+				}
+				code, src := codeBlock(lines[i:j])
+				elements = append(elements, element{kind: elemCode, page: page.Number, parts: code, lines: src})
+				i = j - 1
+				continue
+			}
+
 			trim := strings.TrimSpace(line.text)
 			if line.italic && !strings.HasPrefix(trim, "_") && !strings.HasSuffix(trim, "_") {
 				trim = "_" + trim + "_"
@@ -91,8 +277,10 @@ func renderMarkdown(doc DocumentNode) string {
 				flushPara()
 				if text, ok := stripBullet(trim); ok {
 					listItems = append(listItems, text)
+					listLines = append(listLines, line)
 				} else if text, ok := stripNumericBullet(trim); ok {
 					listItems = append(listItems, text)
+					listLines = append(listLines, line)
 				}
 				continue
 			}
@@ -105,7 +293,7 @@ func renderMarkdown(doc DocumentNode) string {
 				if !res.hasHeader && len(lastTableHeader) > 0 && len(rows) > 0 && len(lastTableHeader) == len(rows[0]) && looksLikeSKU(rows[0][0]) {
 					rows = append([][]string{lastTableHeader}, rows...)
 				}
-				renderTable(&b, rows)
+				elements = append(elements, element{kind: elemTable, page: page.Number, rows: rows, lines: lines[i : i+res.used]})
 				if res.hasHeader && len(rows) > 0 {
 					lastTableHeader = rows[0]
 				}
@@ -119,7 +307,7 @@ func renderMarkdown(doc DocumentNode) string {
 				if strings.HasPrefix(trim, "_") && strings.HasSuffix(trim, "_") && len(trim) > 2 {
 					trim = strings.TrimSuffix(strings.TrimPrefix(trim, "_"), "_")
 				}
-				b.WriteString("_" + trim + "_\n\n")
+				emit(elemAside, 0, []string{trim}, []lineStyle{line})
 				continue
 			}
 
@@ -128,40 +316,66 @@ func renderMarkdown(doc DocumentNode) string {
 			if firstHeading && pageIdx == 0 && isHeading {
 				flushList()
 				flushPara()
-				b.WriteString("# " + trim + "\n\n")
+				emit(elemHeading, 1, []string{trim}, []lineStyle{line})
 				firstHeading = false
 				continue
 			}
 			if isHeading {
 				flushList()
 				flushPara()
-				b.WriteString("## " + trim + "\n\n")
+				emit(elemHeading, 2, []string{trim}, []lineStyle{line})
 				continue
 			}
 
 			if strings.HasSuffix(trim, ":") && len(trim) < 60 {
 				flushPara()
-				b.WriteString("## " + trim + "\n\n")
+				emit(elemHeading, 2, []string{trim}, []lineStyle{line})
 				continue
 			}
 
 			para = append(para, trim)
+			paraLines = append(paraLines, line)
 		}
 		flushList()
 		flushPara()
 
-		if len(doc.Pages) > 1 && pageIdx != len(doc.Pages)-1 {
-			b.WriteString("\n")
-		}
+		linkFootnotes(elements, notes, page.Number, footnoteLabels)
+		pages = append(pages, renderedPage{number: page.Number, label: page.Label, elements: elements, footnotes: notes})
 	}
 
-	return strings.TrimRight(b.String(), "\n") + "\n"
+	return pages
+}
+
+// newLineStyle describes a line of spans; text is empty when the spans
+// carry none.
+func newLineStyle(spans []TextSpan) lineStyle {
+	text := strings.TrimSpace(joinSpans(spans))
+	if text == "" {
+		return lineStyle{}
+	}
+	return lineStyle{
+		text:     normalizeSpaces(text),
+		fontSize: maxSpanSize(spans),
+		spans:    spans,
+		xs:       spanStarts(spans),
+		italic:   spansAreItalic(spans),
+		y:        spans[0].Pos.Y,
+	}
 }
 
 func joinSpans(spans []TextSpan) string {
 	var b strings.Builder
 	var lastText string
+	var last TextSpan
 	var haveLast bool
+	var code []string // run of monospaced words, written as one code span
+
+	flushCode := func() {
+		if len(code) > 0 {
+			b.WriteString(inlineCode(strings.Join(code, " ")))
+			code = nil
+		}
+	}
 
 	for _, span := range spans {
 		text := strings.TrimSpace(span.Text)
@@ -169,18 +383,36 @@ func joinSpans(spans []TextSpan) string {
 			continue
 		}
 
-		if haveLast && !isPunctuation(text) && !strings.HasSuffix(lastText, "-") {
-			b.WriteString(" ")
+		attached := haveLast && (span.Pos.Script != "" || last.Pos.Script != "") && touches(last.Pos, span.Pos)
+		space := haveLast && !attached && !isPunctuation(text) && !strings.HasSuffix(lastText, "-") && !joinsTight(lastText, text)
+		if isCodeSpan(span) {
+			if len(code) == 0 && space {
+				b.WriteString(" ")
+			}
+			code = append(code, text)
+		} else {
+			flushCode()
+			if space {
+				b.WriteString(" ")
+			}
+			b.WriteString(scriptMarkup(text, span.Pos.Script))
 		}
-
-		b.WriteString(text)
 		lastText = text
+		last = span
 		haveLast = true
 	}
+	flushCode()
 
 	return b.String()
 }
 
+// touches reports whether b starts where a ends, with less than a word gap
+// in between.
+func touches(a, b Position) bool {
+	gap := b.X - (a.X + a.Width)
+	return gap < math.Max(wordGapFloor, math.Max(a.FontSize, b.FontSize)*wordGapScale)
+}
+
 var bulletPrefixes = []string{"•", "-", "*", "‣", "▪", "◦", "●", "–", "—", "·", "→", "»", "›"}
 
 func stripBullet(s string) (string, bool) {
@@ -718,21 +950,22 @@ func nearest(values []float64, target float64) int {
 	return closestIdx
 }
 
-func renderTable(b *strings.Builder, rows [][]string) {
+func tableMarkdown(rows [][]string) string {
 	if len(rows) == 0 {
-		return
+		return ""
 	}
 	header := rows[0]
 	sep := make([]string, len(header))
 	for i := range sep {
 		sep[i] = "---"
 	}
-	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
-	b.WriteString("| " + strings.Join(sep, " | ") + " |\n")
+	out := make([]string, 0, len(rows)+1)
+	out = append(out, "| "+strings.Join(header, " | ")+" |")
+	out = append(out, "| "+strings.Join(sep, " | ")+" |")
 	for _, row := range rows[1:] {
-		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		out = append(out, "| "+strings.Join(row, " | ")+" |")
 	}
-	b.WriteString("\n")
+	return strings.Join(out, "\n")
 }
 
 func mergeStarts(xs []float64, tol float64) []float64 {
@@ -774,7 +1007,7 @@ func isPunctuation(s string) bool {
 		return false
 	}
 	for _, r := range s {
-		if !strings.ContainsRune(",.;:!?\"'()-", r) {
+		if !strings.ContainsRune(",.;:!?\"'()-", r) && !isWidePunctuation(r) {
 			return false
 		}
 	}
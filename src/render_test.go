@@ -0,0 +1,273 @@
+package yapp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func TestSourceMap(t *testing.T) {
+	md, sm := renderMarkdown(chunkTestDoc(), FlavorCommonMark)
+
+	want := map[string]SourceSpan{
+		"Getting Started":                      {Page: 1, X: 72, Y: 660, Height: 18},
+		"and the paragraph continues here":     {Page: 1, X: 72, Y: 628, Height: 10},
+		"run the tool":                         {Page: 1, X: 72, Y: 598, Height: 10},
+		"closing words for the reference part": {Page: 2, X: 72, Y: 680, Height: 10},
+	}
+	last := 0
+	for _, s := range sm {
+		if s.Start < last || s.End <= s.Start || s.End > len(md) {
+			t.Fatalf("bad range %d-%d after %d in %d bytes", s.Start, s.End, last, len(md))
+		}
+		last = s.End
+		text := md[s.Start:s.End]
+		w, ok := want[text]
+		if !ok {
+			continue
+		}
+		delete(want, text)
+		if s.Page != w.Page || s.X != w.X || s.Y != w.Y || s.Height != w.Height || s.Width <= 0 {
+			t.Errorf("%q mapped to %+v, want %+v", text, s, w)
+		}
+	}
+	for text := range want {
+		t.Errorf("no source span for %q", text)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	path := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (E = mc) Tj ET "+
+		"BT /F1 7 Tf 110 705 Td (2) Tj ET "+
+		"BT /F1 12 Tf 72 650 Td (Water is H) Tj /F1 7 Tf -3 Ts (2) Tj /F1 12 Tf 0 Ts (O here) Tj ET")
+
+	for _, tc := range []struct {
+		flavor   MarkdownFlavor
+		sup, sub string
+	}{
+		{FlavorCommonMark, "E = mc<sup>2</sup>", "Water is H<sub>2</sub>O here"},
+		{FlavorPandoc, "E = mc^2^", "Water is H~2~O here"},
+	} {
+		result, err := ParseFileWithOptions(path, Options{Flavor: tc.flavor})
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		for _, want := range []string{tc.sup, tc.sub} {
+			if !strings.Contains(result.Markdown, want) {
+				t.Errorf("flavor %d: markdown %q lacks %q", tc.flavor, result.Markdown, want)
+			}
+		}
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	path := writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (The claim is well known) Tj ET "+
+		"BT /F1 7 Tf 205.5 705 Td (1) Tj ET "+
+		"BT /F1 12 Tf 72 685 Td (and it is repeated here often enough.) Tj ET "+
+		"BT /F1 8 Tf 72 100 Td (1 See the original paper for the) Tj ET "+
+		"BT /F1 8 Tf 72 90 Td (full derivation.) Tj ET")
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "The claim is well known[^1] and it is repeated here often enough.\n\n" +
+		"[^1]: See the original paper for the full derivation.\n"
+	if result.Markdown != want {
+		t.Fatalf("markdown =\n%q\nwant\n%q", result.Markdown, want)
+	}
+
+	// A reference set small rather than raised is linked too, and an
+	// exponent with the same text stays an exponent.
+	path = writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (The area grows as x) Tj /F1 7 Tf 5 Ts (2) Tj "+
+		"/F1 12 Tf 0 Ts ( as the paper notes ) Tj /F1 8 Tf (2) Tj /F1 12 Tf (, and more) Tj ET "+
+		"BT /F1 12 Tf 72 685 Td (text follows here to fill the page body.) Tj ET "+
+		"BT /F1 8 Tf 72 100 Td (2 See the original paper.) Tj ET")
+	if result, err = ParseFile(path); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, want := range []string{"x<sup>2</sup>", "notes [^2], and more", "\n\n[^2]: See the original paper.\n"} {
+		if !strings.Contains(result.Markdown, want) {
+			t.Errorf("markdown %q lacks %q", result.Markdown, want)
+		}
+	}
+}
+
+func TestFootersAreNotFootnotes(t *testing.T) {
+	page := func(n int, body string) string {
+		return "BT /F1 12 Tf 72 700 Td (" + body + ") Tj ET " +
+			fmt.Sprintf("BT /F1 9 Tf 72 40 Td (%d Chapter 2. Methods) Tj ET", n)
+	}
+	path := writeTestPDF(t, page(1, "Samples were taken weekly."), page(2, "Results follow below."))
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "## Page 1\n\nSamples were taken weekly.\n\n\n## Page 2\n\nResults follow below.\n"
+	if result.Markdown != want {
+		t.Errorf("markdown = %q, want %q", result.Markdown, want)
+	}
+
+	result, err = ParseFileWithOptions(path, Options{KeepArtifacts: true})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if strings.Contains(result.Markdown, "[^") || !strings.Contains(result.Markdown, "2 Chapter 2. Methods") {
+		t.Errorf("kept footer rendered as a footnote:\n%s", result.Markdown)
+	}
+	last := result.AST.Pages[1].Blocks[len(result.AST.Pages[1].Blocks)-1].Lines[0].Spans[0]
+	if last.Pos.Artifact != "Footer" {
+		t.Errorf("footer artifact = %q, want Footer", last.Pos.Artifact)
+	}
+
+	// A small numbered line nothing refers to stays body text.
+	path = writeTestPDF(t, "BT /F1 12 Tf 72 700 Td (The table lists the results.) Tj ET "+
+		"BT /F1 8 Tf 72 100 Td (3 Results are given in grams.) Tj ET")
+	if result, err = ParseFile(path); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if strings.Contains(result.Markdown, "[^") {
+		t.Errorf("unreferenced line became a footnote:\n%s", result.Markdown)
+	}
+}
+
+func TestCodeBlocks(t *testing.T) {
+	path := writeTestPDF(t, "BT /F1 10 Tf 72 740 Td (Call it with) Tj ET BT /F2 10 Tf 125 740 Td (make build) Tj ET "+
+		"BT /F1 10 Tf 190 740 Td (first.) Tj ET "+
+		"BT /F2 10 Tf 72 700 Td (func main\\(\\) {) Tj ET "+
+		"BT /F2 10 Tf 96 688 Td (x :=  1) Tj ET "+
+		"BT /F2 10 Tf 72 676 Td (}) Tj ET "+
+		"BT /F1 10 Tf 72 640 Td (The program above prints nothing and exits at once.) Tj ET")
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "Call it with `make build` first.\n\n" +
+		"```\nfunc main() {\n    x :=  1\n}\n```\n\n" +
+		"The program above prints nothing and exits at once.\n"
+	if result.Markdown != want {
+		t.Fatalf("markdown =\n%s\nwant\n%s", result.Markdown, want)
+	}
+
+	// A page that is mostly code is still code under its caption.
+	path = writeTestPDF(t, "BT /F1 12 Tf 72 740 Td (Listing 3:) Tj ET "+
+		"BT /F2 10 Tf 72 712 Td (func main\\(\\) {) Tj ET "+
+		"BT /F2 10 Tf 96 700 Td (for i := 0; i < 10; i++ {) Tj ET "+
+		"BT /F2 10 Tf 120 688 Td (fmt.Println\\(i\\)) Tj ET "+
+		"BT /F2 10 Tf 96 676 Td (}) Tj ET "+
+		"BT /F2 10 Tf 72 664 Td (}) Tj ET")
+	if result, err = ParseFile(path); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "```\nfunc main() {\n    for i := 0; i < 10; i++ {\n        fmt.Println(i)\n    }\n}\n```\n"; !strings.HasSuffix(result.Markdown, want) {
+		t.Errorf("mostly-code page = %q, want it to end in %q", result.Markdown, want)
+	}
+
+	// A document typed in Courier throughout is prose, not one code block.
+	path = writeTestPDF(t, "BT /F2 10 Tf 72 700 Td (The parties agree as follows.) Tj ET "+
+		"BT /F2 10 Tf 72 688 Td (Payment is due within thirty days.) Tj ET")
+	if result, err = ParseFile(path); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "The parties agree as follows. Payment is due within thirty days.\n"; result.Markdown != want {
+		t.Errorf("typewritten page = %q, want %q", result.Markdown, want)
+	}
+
+	for name, want := range map[string]bool{
+		"ABCDEF+CourierNewPSMT": true, "SourceCodePro-Regular": true, "DejaVuSansMono": true,
+		"IBMPlexMono-Bold": true, "MonotypeCorsiva": false, "Code2000": false, "Helvetica": false,
+	} {
+		if got := isMonospaced(pdf.Value{}, name); got != want {
+			t.Errorf("isMonospaced(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCJKJoining(t *testing.T) {
+	doc := DocumentNode{Pages: []PageNode{{Number: 1, Blocks: []BlockNode{{Lines: []LineNode{
+		testLine(1, 700, 10, "日本語の 文章は 、 空白なしで"),
+		testLine(1, 688, 10, "書かれる 。"),
+		testLine(1, 676, 10, "Go と Rust （ 言語 ）"),
+	}}}}}}
+	md, _ := renderMarkdown(doc, FlavorCommonMark)
+	want := "日本語の文章は、空白なしで書かれる。Go と Rust（言語）\n"
+	if md != want {
+		t.Fatalf("markdown = %q, want %q", md, want)
+	}
+}
+
+func TestTaggedStructure(t *testing.T) {
+	tagged := func(mcid int, x, y float64, text string) string {
+		return fmt.Sprintf("/P << /MCID %d >> BDC BT /F1 12 Tf %g %g Td (%s) Tj ET EMC ", mcid, x, y, text)
+	}
+	path := writeTestPDF(t,
+		catalogAttrs(`/MarkInfo << /Marked true >> /StructTreeRoot << /Type /StructTreeRoot /RoleMap << /Heading /H1 >>
+			/K << /S /Document /K [
+				<< /S /Heading /Pg 7 0 R /K 0 >>
+				<< /S /P /Pg 7 0 R /K [1 << /Type /MCR /MCID 9 >>] >>
+				<< /S /Table /Pg 7 0 R /K [
+					<< /S /TR /K [<< /S /TH /K 2 >> << /S /TH /K 3 >>] >>
+					<< /S /TR /K [<< /S /TD /K 4 >> << /S /TD /K 5 >>] >> ] >>
+				<< /S /L /Pg 7 0 R /K [
+					<< /S /LI /K [<< /S /Lbl /K 6 >> << /S /LBody /K 7 >>] >>
+					<< /S /LI /K << /S /LBody /K 8 >> >> ] >>
+			] >> >>`),
+		"BT /F1 12 Tf 72 740 Td (Untagged note:) Tj ET "+
+			tagged(1, 72, 700, "Body text")+tagged(9, 72, 686, "continues here.")+
+			tagged(2, 72, 640, "Name")+tagged(3, 200, 640, "Qty")+
+			tagged(4, 72, 626, "Apple")+tagged(5, 200, 626, "3")+
+			tagged(6, 72, 590, "\\225")+tagged(7, 84, 590, "First item")+
+			tagged(8, 84, 576, "Second item")+
+			// The heading is drawn last and at the bottom; the structure
+			// tree still puts it first.
+			tagged(0, 72, 400, "Overview"),
+	)
+	result, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "## Untagged note:\n\n# Overview\n\nBody text continues here.\n\n" +
+		"| Name | Qty |\n| --- | --- |\n| Apple | 3 |\n\n- First item\n- Second item\n"
+	if result.Markdown != want {
+		t.Fatalf("markdown =\n%s\nwant\n%s", result.Markdown, want)
+	}
+	if got := result.AST.Structure[1]; got.Type != "H1" || got.Parent != 1 {
+		t.Errorf("structure[1] = %+v, want the role-mapped H1 under the document", got)
+	}
+
+	// Pages are told apart by object, not by what their dictionaries say:
+	// these two share their content stream, object 6, and differ only in
+	// the structure their text belongs to.
+	path = writeTestPDF(t,
+		catalogAttrs("/StructTreeRoot << /K [<< /S /H1 /Pg 7 0 R /K 0 >> << /S /P /Pg 9 0 R /K 0 >>] >>"),
+		tagged(0, 72, 400, "Same words"), pageAttrs("/Contents 6 0 R", ""))
+	for _, tc := range []struct {
+		pages PageSelection
+		want  string
+	}{
+		{nil, "## Page 1\n\n# Same words\n\n\n## Page 2\n\nSame words\n"},
+		{PageSelection{{First: 2, Last: 2}}, "Same words\n"},
+	} {
+		result, err = ParseFileWithOptions(path, Options{Pages: tc.pages})
+		if err != nil {
+			t.Fatalf("parse pages %v: %v", tc.pages, err)
+		}
+		if result.Markdown != tc.want {
+			t.Errorf("pages %v: markdown = %q, want %q", tc.pages, result.Markdown, tc.want)
+		}
+	}
+
+	// A structure tree the pdf package cannot load leaves the layout to
+	// the heuristics. Object 8 is page 2's content stream.
+	path = writeTestPDF(t, catalogAttrs("/StructTreeRoot << /K 8 0 R >>"),
+		"BT /F1 12 Tf 72 700 Td (Body text) Tj ET", "BT /F1 12 Tf 72 700 Td (Lost) Tj ET")
+	corruptObject(t, path, 8)
+	result, err = ParseFileWithOptions(path, Options{Lenient: true})
+	if err != nil {
+		t.Fatalf("parse with a broken structure tree: %v", err)
+	}
+	if result.Markdown != "Body text\n" || len(result.AST.Structure) != 0 {
+		t.Errorf("markdown = %q, structure = %v; want the untagged body", result.Markdown, result.AST.Structure)
+	}
+}
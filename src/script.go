@@ -0,0 +1,154 @@
+package yapp
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// scriptSizeRatio is the largest size, relative to the line's body text,
+	// a glyph can have and still be a superscript or subscript.
+	scriptSizeRatio = 0.85
+	// superShift and subShift are how far, in body font sizes, a glyph has
+	// to sit above or below the line's baseline.
+	superShift = 0.2
+	subShift   = 0.1
+	// scriptReach is how far, in body font sizes, a line of small glyphs can
+	// be from its neighbour and still belong to it.
+	scriptReach = 0.6
+)
+
+// lineBody returns the font size most of a line's characters are set in and
+// the Y those characters sit on.
+func lineBody(line []glyph) (size, y float64) {
+	counts := make(map[float64]int)
+	for _, g := range line {
+		if strings.TrimSpace(g.S) != "" {
+			counts[math.Round(g.FontSize*10)/10]++
+		}
+	}
+	best := -1
+	for s, n := range counts {
+		if n > best || (n == best && s > size) {
+			size, best = s, n
+		}
+	}
+	for _, g := range line {
+		if math.Abs(g.FontSize-size) <= 0.05 {
+			return size, g.Y
+		}
+	}
+	return size, line[0].Y
+}
+
+// attachScriptLines merges lines made only of small glyphs into the line
+// they are raised above or lowered below. groupLines splits those off when
+// the shift is bigger than its tolerance, as it is for most superscripts.
+func attachScriptLines(lines [][]glyph) [][]glyph {
+	for i := 0; i < len(lines); i++ {
+		_, y := lineBody(lines[i])
+		size := maxFontSize(lines[i])
+		best, bestDist := -1, math.Inf(1)
+		for _, j := range []int{i - 1, i + 1} {
+			if j < 0 || j >= len(lines) {
+				continue
+			}
+			hostSize, hostY := lineBody(lines[j])
+			dist := math.Abs(y - hostY)
+			if size > hostSize*scriptSizeRatio || dist > hostSize*scriptReach || !overlapsX(lines[i], lines[j], hostSize) {
+				continue
+			}
+			if dist < bestDist {
+				best, bestDist = j, dist
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		merged := append(append([]glyph(nil), lines[best]...), lines[i]...)
+		sort.Sort(glyphsHorizontal(merged))
+		lines[best] = merged
+		lines = append(lines[:i], lines[i+1:]...)
+		i--
+	}
+	return lines
+}
+
+// overlapsX reports whether a's horizontal extent falls within b's,
+// allowing slack on either side.
+func overlapsX(a, b []glyph, slack float64) bool {
+	ax0, ax1 := lineExtent(a)
+	bx0, bx1 := lineExtent(b)
+	return ax0 >= bx0-slack && ax1 <= bx1+slack
+}
+
+func lineExtent(line []glyph) (x0, x1 float64) {
+	x0, x1 = math.Inf(1), math.Inf(-1)
+	for _, g := range line {
+		x0 = math.Min(x0, g.X)
+		x1 = math.Max(x1, g.X+glyphAdvance(g))
+	}
+	return x0, x1
+}
+
+// markScripts flags the glyphs of a line that are smaller than its body text
+// and raised above or lowered below its baseline.
+func markScripts(line []glyph) {
+	size, y := lineBody(line)
+	if size <= 0 {
+		return
+	}
+	for i := range line {
+		g := &line[i]
+		if g.FontSize > size*scriptSizeRatio || strings.TrimSpace(g.S) == "" {
+			continue
+		}
+		switch shift := g.Y - y; {
+		case shift > size*superShift:
+			g.script = ScriptSuper
+		case shift < -size*subShift:
+			g.script = ScriptSub
+		}
+	}
+}
+
+// scriptMarkup wraps superscript and subscript text in the HTML tags that
+// CommonMark passes through; write rewrites them for other flavors.
+func scriptMarkup(text string, s Script) string {
+	switch s {
+	case ScriptSuper:
+		return "<sup>" + text + "</sup>"
+	case ScriptSub:
+		return "<sub>" + text + "</sub>"
+	}
+	return text
+}
+
+// applyFlavor rewrites the <sup>/<sub> markup produced by scriptMarkup
+// into the syntax of the requested flavor.
+func applyFlavor(s string, flavor MarkdownFlavor) string {
+	if flavor != FlavorPandoc || !strings.Contains(s, "<su") {
+		return s
+	}
+	for _, tag := range []struct{ open, close, mark string }{
+		{"<sup>", "</sup>", "^"},
+		{"<sub>", "</sub>", "~"},
+	} {
+		for {
+			i := strings.Index(s, tag.open)
+			if i < 0 {
+				break
+			}
+			j := strings.Index(s[i:], tag.close)
+			if j < 0 {
+				break
+			}
+			inner := s[i+len(tag.open) : i+j]
+			// Pandoc ends a script at an unescaped space.
+			inner = strings.ReplaceAll(inner, " ", `\ `)
+			s = s[:i] + tag.mark + inner + tag.mark + s[i+j+len(tag.close):]
+		}
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package yapp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageRange is a run of pages, numbered from 1. A Last of 0 runs to the
+// end of the document.
+type PageRange struct {
+	First, Last int
+}
+
+// PageSelection picks the pages to parse. A nil selection picks them all.
+type PageSelection []PageRange
+
+// ParsePageSelection reads a selection such as "1-3,7,10-": single pages,
+// closed ranges, and ranges open at either end.
+func ParsePageSelection(s string) (PageSelection, error) {
+	var sel PageSelection
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		r := PageRange{First: 1}
+		var err error
+		if first = strings.TrimSpace(first); first != "" {
+			if r.First, err = strconv.Atoi(first); err != nil || r.First < 1 {
+				return nil, fmt.Errorf("invalid page range %q", part)
+			}
+		}
+		switch last = strings.TrimSpace(last); {
+		case !isRange:
+			r.Last = r.First
+		case last != "":
+			if r.Last, err = strconv.Atoi(last); err != nil || r.Last < r.First {
+				return nil, fmt.Errorf("invalid page range %q", part)
+			}
+		case first == "":
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		sel = append(sel, r)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("empty page selection %q", s)
+	}
+	return sel, nil
+}
+
+// Contains reports whether page n is selected.
+func (s PageSelection) Contains(n int) bool {
+	if s == nil {
+		return true
+	}
+	for _, r := range s {
+		if n >= r.First && (r.Last == 0 || n <= r.Last) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PageSelection) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		switch {
+		case r.Last == 0:
+			parts[i] = fmt.Sprintf("%d-", r.First)
+		case r.Last == r.First:
+			parts[i] = strconv.Itoa(r.First)
+		default:
+			parts[i] = fmt.Sprintf("%d-%d", r.First, r.Last)
+		}
+	}
+	return strings.Join(parts, ",")
+}
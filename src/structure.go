@@ -0,0 +1,387 @@
+package yapp
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// maxStructDepth bounds the walk of a structure tree, which malformed files
+// can make cyclic.
+const maxStructDepth = 64
+
+// StructElement is an element of a Tagged PDF's logical structure tree.
+// Text points at the innermost element it belongs to with
+// Position.Element.
+type StructElement struct {
+	ID int `json:"id"`
+	// Type is the standard structure type (P, H1, LI, TD, ...), after
+	// resolving the document's RoleMap.
+	Type   string `json:"type"`
+	Parent int    `json:"parent,omitempty"`
+	// Alt is the element's alternate description, as given for figures.
+	Alt string `json:"alt,omitempty"`
+}
+
+// markedRef names a marked-content sequence: its page and MCID.
+type markedRef struct {
+	page, mcid int
+}
+
+// structTree is a document's structure tree with its marked content
+// indexed.
+type structTree struct {
+	elements []StructElement // ID i is elements[i-1], in document order
+	content  map[markedRef]int
+}
+
+// readStructTree reads the catalog's /StructTreeRoot, indexing the marked
+// content of the selected pages only. Untagged documents give an empty
+// tree, and so do documents whose tree the pdf package cannot read, which
+// are then laid out as if untagged.
+func readStructTree(reader *pdf.Reader, selection PageSelection) (tree structTree) {
+	defer func() {
+		if recover() != nil {
+			tree = structTree{content: make(map[markedRef]int)}
+		}
+	}()
+
+	tree = structTree{content: make(map[markedRef]int)}
+	root := reader.Trailer().Key("Root").Key("StructTreeRoot")
+	if root.Kind() != pdf.Dict {
+		return tree
+	}
+	pages := make(map[objectRef]int)
+	for i := 1; i <= reader.NumPage(); i++ {
+		if !selection.Contains(i) {
+			continue
+		}
+		if ref := refOf(reader.Page(i).V); ref != (objectRef{}) {
+			pages[ref] = i
+		}
+	}
+	w := structWalker{tree: &tree, roles: root.Key("RoleMap"), pages: pages}
+	w.kids(root.Key("K"), 0, 0, 0)
+	return tree
+}
+
+type structWalker struct {
+	tree  *structTree
+	roles pdf.Value
+	pages map[objectRef]int // selected page's object to page number
+}
+
+// objectRef is the number and generation of an indirect object.
+type objectRef struct {
+	id, gen uint64
+}
+
+// refOf returns the indirect object v was read from, or of the object it
+// is part of. The pdf package does not export it, so it is read by
+// reflection.
+func refOf(v pdf.Value) objectRef {
+	ptr := reflect.ValueOf(v).FieldByName("ptr")
+	if ptr.Kind() != reflect.Struct || ptr.NumField() < 2 {
+		return objectRef{}
+	}
+	return objectRef{ptr.Field(0).Uint(), ptr.Field(1).Uint()}
+}
+
+// kids walks the /K entry of the element parent, whose content lies on
+// page unless a kid says otherwise.
+func (w *structWalker) kids(k pdf.Value, parent, page, depth int) {
+	if depth > maxStructDepth {
+		return
+	}
+	switch k.Kind() {
+	case pdf.Array:
+		for i := 0; i < k.Len(); i++ {
+			w.kids(k.Index(i), parent, page, depth)
+		}
+	case pdf.Integer:
+		if parent > 0 && page > 0 {
+			w.tree.content[markedRef{page, int(k.Int64())}] = parent
+		}
+	case pdf.Dict:
+		if pg, ok := w.page(k); ok {
+			page = pg
+		}
+		switch k.Key("Type").Name() {
+		case "MCR":
+			if parent > 0 && page > 0 && k.Key("MCID").Kind() == pdf.Integer {
+				w.tree.content[markedRef{page, int(k.Key("MCID").Int64())}] = parent
+			}
+			return
+		case "OBJR":
+			return
+		}
+		if k.Key("S").Kind() != pdf.Name {
+			return
+		}
+		id := len(w.tree.elements) + 1
+		w.tree.elements = append(w.tree.elements, StructElement{
+			ID:     id,
+			Type:   w.role(k.Key("S").Name()),
+			Parent: parent,
+			Alt:    k.Key("Alt").Text(),
+		})
+		w.kids(k.Key("K"), id, page, depth+1)
+	}
+}
+
+// page returns the page number of the /Pg of element v, 0 for a page that
+// is not selected, and whether v has one.
+func (w *structWalker) page(v pdf.Value) (int, bool) {
+	pg := v.Key("Pg")
+	if pg.Kind() != pdf.Dict {
+		return 0, false
+	}
+	return w.pages[refOf(pg)], true
+}
+
+// role maps a custom structure type to a standard one through the RoleMap.
+func (w *structWalker) role(s string) string {
+	for i := 0; i < 8; i++ {
+		mapped := w.roles.Key(s)
+		if mapped.Kind() != pdf.Name || mapped.Name() == s {
+			break
+		}
+		s = mapped.Name()
+	}
+	return s
+}
+
+// element returns the structure element a glyph on page belongs to, or 0.
+func (t structTree) element(page int, g glyph) int {
+	if len(t.elements) == 0 {
+		return 0
+	}
+	mcid, ok := g.mcid()
+	if !ok {
+		return 0
+	}
+	return t.content[markedRef{page, mcid}]
+}
+
+// structIndex answers the renderer's questions about a structure tree.
+type structIndex struct {
+	elements []StructElement
+}
+
+func newStructIndex(elements []StructElement) *structIndex {
+	if len(elements) == 0 {
+		return nil
+	}
+	for i, e := range elements {
+		if e.ID != i+1 {
+			return nil
+		}
+	}
+	return &structIndex{elements: elements}
+}
+
+func (x *structIndex) get(id int) (StructElement, bool) {
+	if x == nil || id <= 0 || id > len(x.elements) {
+		return StructElement{}, false
+	}
+	return x.elements[id-1], true
+}
+
+// ancestor returns the closest element from id upwards whose type is one
+// of types, or 0.
+func (x *structIndex) ancestor(id int, types ...string) int {
+	for depth := 0; depth <= maxStructDepth; depth++ {
+		e, ok := x.get(id)
+		if !ok {
+			return 0
+		}
+		for _, t := range types {
+			if e.Type == t {
+				return e.ID
+			}
+		}
+		id = e.Parent
+	}
+	return 0
+}
+
+// blockTypes are the structure types rendered as blocks of their own.
+var blockTypes = []string{
+	"H", "H1", "H2", "H3", "H4", "H5", "H6", "Title",
+	"LI", "TD", "TH", "Code", "P", "Caption", "Note", "BlockQuote", "TOCI", "Figure", "Formula",
+}
+
+// block returns the block-level element the text of a span belongs to, or
+// 0 for untagged text.
+func (x *structIndex) block(span TextSpan) int {
+	if x == nil || span.Pos.Element == 0 {
+		return 0
+	}
+	// List items and table cells win over the paragraphs inside them.
+	if id := x.ancestor(span.Pos.Element, "LI", "TD", "TH"); id > 0 {
+		return id
+	}
+	return x.ancestor(span.Pos.Element, blockTypes...)
+}
+
+// headingLevel is the Markdown heading level of a heading structure type,
+// or 0 for other types.
+func headingLevel(t string) int {
+	switch t {
+	case "Title", "H1":
+		return 1
+	case "H":
+		return 2
+	case "H2", "H3", "H4", "H5", "H6":
+		return int(t[1] - '0')
+	}
+	return 0
+}
+
+// splitByBlock cuts lines where their text changes block-level element
+// and puts the pieces in the order of the structure tree. Untagged pieces
+// stay behind the piece they followed.
+func splitByBlock(lines []lineStyle, x *structIndex) []lineStyle {
+	type piece struct {
+		line  lineStyle
+		order int
+	}
+	var pieces []piece
+	order := 0
+	for _, line := range lines {
+		start := 0
+		for i := 1; i <= len(line.spans); i++ {
+			if i < len(line.spans) && x.block(line.spans[i]) == x.block(line.spans[start]) {
+				continue
+			}
+			if b := x.block(line.spans[start]); b > 0 {
+				order = b
+			}
+			if sub := subLine(line, line.spans[start:i]); sub.text != "" {
+				pieces = append(pieces, piece{sub, order})
+			}
+			start = i
+		}
+	}
+	sort.SliceStable(pieces, func(i, j int) bool { return pieces[i].order < pieces[j].order })
+	out := make([]lineStyle, len(pieces))
+	for i, p := range pieces {
+		out[i] = p.line
+	}
+	return out
+}
+
+// subLine is the part of line made of spans.
+func subLine(line lineStyle, spans []TextSpan) lineStyle {
+	if len(spans) == len(line.spans) {
+		return line
+	}
+	return newLineStyle(spans)
+}
+
+// taggedElements renders the tagged lines at the start of lines: a
+// heading, paragraph, list, table or code block built from the structure
+// elements they belong to. It returns how many lines it used, 0 when the
+// first line is untagged.
+func taggedElements(lines []lineStyle, x *structIndex, page int) (element, int) {
+	if len(lines) == 0 || x == nil {
+		return element{}, 0
+	}
+	first := x.block(lines[0].spans[0])
+	if first == 0 {
+		return element{}, 0
+	}
+	e, _ := x.get(first)
+	run := func(same func(id int) bool) int {
+		n := 0
+		for n < len(lines) && same(x.block(lines[n].spans[0])) {
+			n++
+		}
+		return n
+	}
+
+	switch {
+	case e.Type == "LI":
+		list := e.Parent
+		n := run(func(id int) bool { li, ok := x.get(id); return ok && li.Type == "LI" && li.Parent == list })
+		el := element{kind: elemList, page: page}
+		for i := 0; i < n; i++ {
+			text := strings.TrimSpace(lines[i].text)
+			if i > 0 && x.block(lines[i].spans[0]) == x.block(lines[i-1].spans[0]) {
+				last := len(el.parts) - 1
+				el.parts[last] = joinText([]string{el.parts[last], text})
+				continue
+			}
+			if stripped, ok := stripBullet(text); ok {
+				text = stripped
+			} else if stripped, ok := stripNumericBullet(text); ok {
+				text = stripped
+			}
+			el.parts = append(el.parts, text)
+			el.lines = append(el.lines, lines[i])
+		}
+		return el, n
+
+	case e.Type == "TD" || e.Type == "TH":
+		table := x.ancestor(first, "Table")
+		n := run(func(id int) bool { return id > 0 && x.ancestor(id, "Table") == table })
+		return element{kind: elemTable, page: page, rows: taggedRows(lines[:n], x), lines: lines[:n]}, n
+
+	case e.Type == "Code":
+		n := run(func(id int) bool { return id == first })
+		code, src := codeBlock(lines[:n])
+		return element{kind: elemCode, page: page, parts: code, lines: src}, n
+	}
+
+	n := run(func(id int) bool { return id == first })
+	var parts []string
+	for _, line := range lines[:n] {
+		parts = append(parts, strings.TrimSpace(line.text))
+	}
+	if level := headingLevel(e.Type); level > 0 {
+		return element{kind: elemHeading, page: page, level: level, parts: []string{joinText(parts)}, lines: lines[:1]}, n
+	}
+	return element{kind: elemParagraph, page: page, parts: parts, lines: lines[:n]}, n
+}
+
+// taggedRows builds table rows from the TR and TD/TH elements of a tagged
+// table's lines.
+func taggedRows(lines []lineStyle, x *structIndex) [][]string {
+	cells := make(map[int][]TextSpan)
+	var rowIDs []int
+	rowCells := make(map[int][]int)
+	for _, line := range lines {
+		for _, span := range line.spans {
+			cell := x.block(span)
+			row := x.ancestor(cell, "TR")
+			if _, ok := cells[cell]; !ok {
+				if _, ok := rowCells[row]; !ok {
+					rowIDs = append(rowIDs, row)
+				}
+				rowCells[row] = append(rowCells[row], cell)
+			}
+			cells[cell] = append(cells[cell], span)
+		}
+	}
+	sort.Ints(rowIDs)
+	var rows [][]string
+	width := 0
+	for _, r := range rowIDs {
+		ids := rowCells[r]
+		sort.Ints(ids)
+		row := make([]string, len(ids))
+		for i, id := range ids {
+			row[i] = normalizeSpaces(joinSpans(cells[id]))
+		}
+		width = max(width, len(row))
+		rows = append(rows, row)
+	}
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows
+}
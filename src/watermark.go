@@ -0,0 +1,212 @@
+package yapp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// watermarkSize is how many times the page's body text size text has
+	// to be to count as large.
+	watermarkSize = 2.5
+	// lightLuminance is the luminance from which a fill counts as light.
+	lightLuminance = 0.75
+	// whiteLuminance is the luminance from which a light fill is taken for
+	// white text on a coloured band rather than a faint stamp.
+	whiteLuminance = 0.98
+	// watermarkSkew is how many degrees text has to be turned by to count
+	// as rotated, so that slightly skewed scans do not.
+	watermarkSkew = 5
+	// stampGrid is the grid, in points, stamp positions are compared on
+	// across pages.
+	stampGrid = 4
+	// footerMargin is the share of the page's height at its bottom that
+	// running footers are looked for in.
+	footerMargin = 0.15
+)
+
+// stamp is a run of glyphs drawn in one go: one after the other in the
+// same font, size, direction and paint.
+type stamp struct {
+	start, end int // glyph range
+	text       string
+}
+
+// stamps splits a page's glyphs into runs at every jump in position and
+// every change of font, size, rotation, colour, opacity or artifact.
+func stamps(glyphs []glyph) []stamp {
+	var out []stamp
+	for i := 0; i < len(glyphs); {
+		j := i + 1
+		for j < len(glyphs) && sameStamp(glyphs[j-1], glyphs[j]) {
+			j++
+		}
+		var b strings.Builder
+		for _, g := range glyphs[i:j] {
+			b.WriteString(strings.TrimSpace(g.S))
+		}
+		if b.Len() > 0 {
+			out = append(out, stamp{start: i, end: j, text: b.String()})
+		}
+		i = j
+	}
+	return out
+}
+
+func sameStamp(a, b glyph) bool {
+	if a.Font != b.Font || math.Abs(a.FontSize-b.FontSize) >= 0.01 ||
+		math.Abs(a.rotation-b.rotation) >= 0.5 || a.fill != b.fill || a.alpha != b.alpha {
+		return false
+	}
+	_, aArtifact := a.artifact()
+	_, bArtifact := b.artifact()
+	if aArtifact != bArtifact {
+		return false
+	}
+	s, c := math.Sincos(a.rotation * math.Pi / 180)
+	return math.Hypot(b.X-(a.X+a.W*c), b.Y-(a.Y+a.W*s)) <= a.FontSize
+}
+
+// key identifies a stamp across pages by its text and where it starts.
+// Digits are left out of the text so that running footers and headers
+// match whatever page number they carry.
+func (s stamp) key(glyphs []glyph) string {
+	g := glyphs[s.start]
+	text := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return '#'
+		}
+		return r
+	}, s.text)
+	return fmt.Sprintf("%s@%.0f,%.0f", text, math.Round(g.X/stampGrid), math.Round(g.Y/stampGrid))
+}
+
+// repeatedStamps returns the keys of the stamps found on every page that
+// has text, when there are at least two such pages.
+func repeatedStamps(pages []pageRead) map[string]bool {
+	counts := make(map[string]int)
+	withText := 0
+	for _, p := range pages {
+		if p.err != nil || !hasText(p.glyphs) {
+			continue
+		}
+		withText++
+		seen := make(map[string]bool)
+		for _, s := range stamps(p.glyphs) {
+			if k := s.key(p.glyphs); !seen[k] {
+				seen[k] = true
+				counts[k]++
+			}
+		}
+	}
+	repeated := make(map[string]bool)
+	if withText < 2 {
+		return repeated
+	}
+	for k, n := range counts {
+		if n == withText {
+			repeated[k] = true
+		}
+	}
+	return repeated
+}
+
+// markWatermarks flags the watermark and background text of a page and
+// returns how many runs of it there are. A run inside an /Artifact marked
+// as a watermark or background always counts, and so does a faint run,
+// light grey or translucent, repeated at the same place on every page,
+// such as a CONFIDENTIAL stamp at body size. Otherwise a run has to be
+// large next to the page's body text and also rotated, light or
+// translucent, or repeated. Rotated table headers and white-on-colour
+// running headers are not large, and a large title is none of the others.
+func markWatermarks(glyphs []glyph, repeated map[string]bool) int {
+	body := bodyGlyphSize(glyphs)
+	found := 0
+	for _, s := range stamps(glyphs) {
+		g := glyphs[s.start]
+		large := body > 0 && g.FontSize >= body*watermarkSize
+		rotated := math.Abs(g.rotation) >= watermarkSkew || g.vertical
+		translucent := g.alpha > 0 && g.alpha < 1
+		pale := !g.rawFill && g.fill.luminance() >= lightLuminance
+		light := pale || translucent
+		faint := translucent || pale && g.fill.luminance() < whiteLuminance
+		again := repeated[s.key(glyphs)]
+		if !watermarkArtifact(g) && !(faint && again) && !(large && (rotated || light || again)) {
+			continue
+		}
+		found++
+		for i := s.start; i < s.end; i++ {
+			glyphs[i].watermark = true
+		}
+	}
+	return found
+}
+
+// watermarkArtifact reports whether the glyph is in an /Artifact of type
+// /Background or subtype /Watermark.
+func watermarkArtifact(g glyph) bool {
+	props, ok := g.artifact()
+	return ok && (props.Key("Subtype").Name() == "Watermark" || props.Key("Type").Name() == "Background")
+}
+
+// dropWatermarks returns the glyphs markWatermarks did not flag.
+func dropWatermarks(glyphs []glyph) []glyph {
+	kept := make([]glyph, 0, len(glyphs))
+	for _, g := range glyphs {
+		if !g.watermark {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// markFooters flags a page's running footer: the stamps that repeat on
+// every page, lie in the bottom margin of the visible page crop, and lie
+// below all of the page's other text.
+func markFooters(glyphs []glyph, repeated map[string]bool, crop Box) {
+	margin := crop[1] + (crop[3]-crop[1])*footerMargin
+	runs := stamps(glyphs)
+	lowest := math.Inf(1) // lowest baseline of text that does not repeat
+	for _, s := range runs {
+		if repeated[s.key(glyphs)] || glyphs[s.start].watermark {
+			continue
+		}
+		for _, g := range glyphs[s.start:s.end] {
+			lowest = math.Min(lowest, g.Y)
+		}
+	}
+	for _, s := range runs {
+		if !repeated[s.key(glyphs)] || glyphs[s.start].watermark {
+			continue
+		}
+		top := math.Inf(-1)
+		for _, g := range glyphs[s.start:s.end] {
+			top = math.Max(top, g.Y)
+		}
+		if top >= lowest || top > margin {
+			continue
+		}
+		for i := s.start; i < s.end; i++ {
+			glyphs[i].footer = true
+		}
+	}
+}
+
+// bodyGlyphSize is the font size most of a page's characters are set in.
+func bodyGlyphSize(glyphs []glyph) float64 {
+	counts := make(map[float64]int)
+	for _, g := range glyphs {
+		if strings.TrimSpace(g.S) != "" {
+			counts[math.Round(g.FontSize*10)/10]++
+		}
+	}
+	size, best := 0.0, 0
+	for s, n := range counts {
+		if n > best || n == best && s < size {
+			size, best = s, n
+		}
+	}
+	return size
+}
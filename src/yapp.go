@@ -4,54 +4,302 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // Result holds the parsed AST and rendered Markdown.
 type Result struct {
 	AST      DocumentNode
 	Markdown string
+	// SourceMap locates the text of Markdown on the pages, for citations
+	// and highlighting. Spans are in output order.
+	SourceMap []SourceSpan
+	// Pages reports what was found on every page parsed, including the ones
+	// that produced no text and are therefore missing from AST.
+	Pages []PageReport
+	// PageErrors lists the pages skipped in lenient mode.
+	PageErrors []*PageError
+	// Fonts reports how well the text of each font could be decoded.
+	Fonts []FontReport
 }
 
-// ParseFile converts a PDF into a structured AST and Markdown string.
+// PageStatus classifies a page by what the lexer could get out of it.
+type PageStatus string
+
+const (
+	PageText        PageStatus = "text"         // text layer decoded
+	PageOCR         PageStatus = "ocr"          // no text layer; text came from the OCR engine
+	PageImageOnly   PageStatus = "image-only"   // images but no text; needs OCR
+	PageEmpty       PageStatus = "empty"        // neither text nor images
+	PageDecodeError PageStatus = "decode-error" // content stream could not be read
+	PageEncrypted   PageStatus = "encrypted"    // content could not be decrypted
+)
+
+// PageReport is the per-page diagnostic carried on Result.
+type PageReport struct {
+	Number int        `json:"number"`
+	Status PageStatus `json:"status"`
+	Words  int        `json:"words"`
+	Images int        `json:"images,omitempty"`
+	OCR    bool       `json:"ocr,omitempty"`
+	// Replacements counts the characters GlyphMap replaced and the words
+	// Unicode normalization changed.
+	Replacements int `json:"replacements,omitempty"`
+	// Garbled is set when over a fifth of the page's characters could not
+	// be decoded or are in fonts whose letters are implausible (see
+	// FontReport), which usually means a font without a usable encoding.
+	Garbled bool `json:"garbled,omitempty"`
+	// Watermarks counts the runs of watermark and background text found.
+	Watermarks int `json:"watermarks,omitempty"`
+	// OffPage counts the characters drawn outside the page's CropBox.
+	OffPage int    `json:"offPage,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// InvisibleText selects what happens to text that is never painted, such as
+// render mode 3 glyphs or text outside the clipping path.
+type InvisibleText int
+
+const (
+	// InvisibleAuto keeps invisible text only on pages that have no visible
+	// text at all, which is what an OCR layer over a scanned image looks like.
+	InvisibleAuto InvisibleText = iota
+	// InvisibleInclude keeps all text, painted or not.
+	InvisibleInclude
+	// InvisibleExclude drops invisible text everywhere.
+	InvisibleExclude
+)
+
+// MarkdownFlavor selects the syntax for constructs Markdown dialects
+// disagree on.
+type MarkdownFlavor int
+
+const (
+	// FlavorCommonMark uses inline HTML where CommonMark has no syntax,
+	// such as <sup> and <sub>.
+	FlavorCommonMark MarkdownFlavor = iota
+	// FlavorPandoc uses Pandoc's extensions: ^super^ and ~sub~.
+	FlavorPandoc
+)
+
+// Options tunes how a PDF is parsed. The zero value is what ParseFile uses.
+type Options struct {
+	InvisibleText InvisibleText
+	// OCR, when set, is asked for the text of pages without a text layer.
+	OCR OCREngine
+	// OCRGarbled also sends garbled pages to OCR instead of emitting the
+	// text their fonts decode to.
+	OCRGarbled bool
+	// KeepWatermarks keeps watermark and background text, marked with
+	// Position.Watermark, instead of dropping it.
+	KeepWatermarks bool
+	// KeepArtifacts keeps text marked as an /Artifact, such as running
+	// headers, footers and page numbers in tagged PDFs, marked with
+	// Position.Artifact, instead of dropping it. Untagged running footers,
+	// text repeated at the foot of every page, count as Footer artifacts.
+	KeepArtifacts bool
+	// KeepOffPage keeps text outside the page's CropBox, such as crop
+	// marks, slugs and job tickets, marked with Position.OffPage, instead
+	// of dropping it.
+	KeepOffPage bool
+	// Password opens encrypted PDFs that need a user password. It is also
+	// handed to the OCR engine in OCRPage.Password; TesseractOCR passes it to
+	// qpdf in a private temporary file, never on a command line.
+	Password string
+	// Lenient skips pages that fail to decode instead of failing the whole
+	// document; the failures are listed on Result.PageErrors.
+	Lenient bool
+	// Pages selects the pages to parse; nil parses them all. Pages keep
+	// their numbers in the document.
+	Pages PageSelection
+	// Flavor selects the Markdown dialect of Result.Markdown.
+	Flavor MarkdownFlavor
+	// Normalization is the Unicode normalization applied to the text. The
+	// default is NFC.
+	Normalization Normalization
+	// GlyphMap adds to or overrides the table GlyphReplacements returns,
+	// which by default expands ligatures, maps symbol font bullets and drops
+	// invisible characters; map a character to itself to keep it.
+	GlyphMap map[rune]string
+}
+
+// ParseFile converts a PDF into a structured AST and Markdown string. The
+// text is cleaned up by default: NFC normalized, with the characters in
+// GlyphReplacements replaced, and words broken across lines at a soft
+// hyphen joined up again. Options.Normalization and Options.GlyphMap turn
+// the first two off.
 func ParseFile(inputPath string) (Result, error) {
+	return ParseFileWithOptions(inputPath, Options{})
+}
+
+// ParseFileWithOptions is ParseFile with explicit options.
+func ParseFileWithOptions(inputPath string, opts Options) (Result, error) {
 	if inputPath == "" {
 		return Result{}, fmt.Errorf("input path is required")
 	}
 
-	tokens, err := NewLexer(inputPath).Tokenize()
+	lexer := NewLexerWithOptions(inputPath, opts)
+	tokens, err := lexer.Tokenize()
 	if err != nil {
 		return Result{}, fmt.Errorf("lexing failed: %w", err)
 	}
 
 	ast := NewParser(tokens).Parse()
-	markdown := renderMarkdown(ast)
-	return Result{AST: ast, Markdown: markdown}, nil
+	ast.Structure = lexer.Structure()
+	geometry := lexer.Geometry()
+	for i := range ast.Pages {
+		ast.Pages[i].PageGeometry = geometry[ast.Pages[i].Number]
+	}
+	markdown, sourceMap := renderMarkdown(ast, opts.Flavor)
+	return Result{AST: ast, Markdown: markdown, SourceMap: sourceMap, Pages: lexer.Reports(), PageErrors: lexer.PageErrors(), Fonts: lexer.Fonts()}, nil
 }
 
 // Run converts a PDF to Markdown and writes it to disk. Suitable for CLI use.
 func Run(inputPath, outputPath string, enableDebug bool) error {
+	_, err := RunWithOptions(inputPath, outputPath, enableDebug, Options{})
+	return err
+}
+
+// RunWithOptions is Run with explicit parse options. It returns the parse
+// result, whose Summary is worth showing the user.
+func RunWithOptions(inputPath, outputPath string, enableDebug bool, opts Options) (Result, error) {
 	if inputPath == "" || outputPath == "" {
-		return fmt.Errorf("both input and output paths are required")
+		return Result{}, fmt.Errorf("both input and output paths are required")
 	}
 
-	result, err := ParseFile(inputPath)
+	result, err := ParseFileWithOptions(inputPath, opts)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 
 	if enableDebug {
 		pretty, err := json.MarshalIndent(result.AST, "", "  ")
 		if err != nil {
-			return fmt.Errorf("debug: marshal AST: %w", err)
+			return Result{}, fmt.Errorf("debug: marshal AST: %w", err)
 		}
 		fmt.Println(string(pretty))
 	}
 
 	if err := writeMarkdown(outputPath, result.Markdown); err != nil {
-		return fmt.Errorf("write failed: %w", err)
+		return Result{}, fmt.Errorf("write failed: %w", err)
 	}
+	return result, nil
+}
 
-	return nil
+// RunChunks parses a PDF and writes its chunks to disk as JSON Lines. It
+// returns the parse result, like RunWithOptions.
+func RunChunks(inputPath, outputPath string, opts Options, chunkOpts ChunkOptions) (Result, error) {
+	if inputPath == "" || outputPath == "" {
+		return Result{}, fmt.Errorf("both input and output paths are required")
+	}
+
+	result, err := ParseFileWithOptions(inputPath, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	docHash, err := FileSHA256(inputPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("hash input: %w", err)
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return Result{}, fmt.Errorf("write failed: %w", err)
+	}
+	records := ChunkRecords(inputPath, docHash, result.Chunks(chunkOpts))
+	if err := WriteChunksJSONL(f, records); err != nil {
+		f.Close()
+		return Result{}, fmt.Errorf("write failed: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return Result{}, fmt.Errorf("write failed: %w", err)
+	}
+	return result, nil
+}
+
+// pageStatusNotes says what an operator should do about a non-text page.
+var pageStatusNotes = []struct {
+	status PageStatus
+	note   string
+}{
+	{PageOCR, "text from OCR, spot-check"},
+	{PageImageOnly, "needs OCR"},
+	{PageEmpty, "no content"},
+	{PageDecodeError, "manual review"},
+	{PageEncrypted, "could not decrypt"},
+}
+
+// Summary describes for a human what went wrong on the document's pages
+// and with its fonts: the pages that did not yield a text layer and the
+// fonts whose text came out garbled. It is empty when nothing did.
+func (r Result) Summary() string {
+	return pageSummary(r.Pages) + fontSummary(r.Fonts)
+}
+
+// pageSummary describes the page reports for a human, listing the pages
+// that did not yield a text layer. It is empty when every page did.
+func pageSummary(reports []PageReport) string {
+	byStatus := make(map[PageStatus][]int)
+	for _, r := range reports {
+		byStatus[r.Status] = append(byStatus[r.Status], r.Number)
+	}
+	if len(byStatus[PageText]) == len(reports) {
+		return ""
+	}
+
+	var b strings.Builder
+	counts := []string{fmt.Sprintf("%d text", len(byStatus[PageText]))}
+	for _, s := range pageStatusNotes {
+		if n := len(byStatus[s.status]); n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, s.status))
+		}
+	}
+	fmt.Fprintf(&b, "%d pages: %s\n", len(reports), strings.Join(counts, ", "))
+	for _, s := range pageStatusNotes {
+		if pages := byStatus[s.status]; len(pages) > 0 {
+			fmt.Fprintf(&b, "  %s (%s): %s\n", s.status, s.note, formatPageList(pages))
+		}
+	}
+	return b.String()
+}
+
+// fontSummary lists the fonts whose text came out garbled. It is empty
+// when there are none.
+func fontSummary(fonts []FontReport) string {
+	var b strings.Builder
+	for _, f := range fonts {
+		if !f.Garbled {
+			continue
+		}
+		note := "no ToUnicode map"
+		if f.ToUnicode {
+			note = "broken ToUnicode map"
+		}
+		if f.Implausible {
+			note += ", implausible letters"
+		}
+		fmt.Fprintf(&b, "font %s garbled (%s): %d of %d characters undecodable on pages %s\n",
+			f.Name, note, f.Replacement+f.Suspicious, f.Chars, formatPageList(f.Pages))
+	}
+	return b.String()
+}
+
+// formatPageList renders ascending page numbers compactly, e.g. "1-3, 7".
+func formatPageList(pages []int) string {
+	var parts []string
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		} else {
+			parts = append(parts, fmt.Sprint(pages[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
 }
 func writeMarkdown(outPath, content string) error {
 	if err := os.WriteFile(outPath, []byte(content), 0o644); err != nil {
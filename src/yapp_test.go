@@ -175,3 +175,23 @@ func slugFromPath(p string) string {
 	}
 	return base
 }
+
+func TestPageSummary(t *testing.T) {
+	reports := []PageReport{
+		{Number: 1, Status: PageText},
+		{Number: 2, Status: PageImageOnly},
+		{Number: 3, Status: PageImageOnly},
+		{Number: 4, Status: PageImageOnly},
+		{Number: 5, Status: PageEmpty},
+		{Number: 6, Status: PageImageOnly},
+	}
+	want := "6 pages: 1 text, 4 image-only, 1 empty\n" +
+		"  image-only (needs OCR): 2-4, 6\n" +
+		"  empty (no content): 5\n"
+	if got := pageSummary(reports); got != want {
+		t.Fatalf("pageSummary =\n%s\nwant\n%s", got, want)
+	}
+	if got := pageSummary(reports[:1]); got != "" {
+		t.Fatalf("pageSummary for all-text document = %q, want empty", got)
+	}
+}